@@ -46,6 +46,7 @@ var (
 	cleanerCount      = flag.Int("cleaner-count", defaultCleanerCount, "Number of threads running cleanup")
 	namespace         = flag.String("namespace", corev1.NamespaceDefault, "Kubernetes namespace to query")
 	serviceAccount    = flag.String("service-account", "", "Path to projects service account")
+	tokenAuth         = flag.Bool("kubeconfig-token-auth", false, "Use an access token instead of the gcp auth provider in the kubeconfig of resources")
 	kubeClientOptions crds.KubernetesClientOptions
 )
 
@@ -70,6 +71,9 @@ func main() {
 	if err != nil {
 		logrus.WithError(err).Fatal("unable to create gcp client")
 	}
+	if *tokenAuth {
+		gcpClient.UseTokenAuth()
+	}
 	gcp.SetClient(gcpClient)
 
 	kubeClient, err := kubeClientOptions.CacheBackedClient(*namespace, &crds.DRLCObject{})
@@ -49,12 +49,14 @@ func defaultKubeconfig() string {
 }
 
 var (
-	owner       = flag.String("owner", "", "")
-	rType       = flag.String("type", "", "Type of resource to acquire")
-	timeoutStr  = flag.String("timeout", defaultTimeout, "Timeout ")
-	kubecfgPath = flag.String("kubeconfig-save", defaultKubeconfig(), "Path to write kubeconfig file to")
-	infoSave    = flag.String("info-save", "", "Path to save info")
-	boskosURL   = flag.String("boskos-url", "http://boskos", "Boskos Server URL")
+	owner          = flag.String("owner", "", "")
+	rType          = flag.String("type", "", "Type of resource to acquire")
+	timeoutStr     = flag.String("timeout", defaultTimeout, "Timeout ")
+	kubecfgPath    = flag.String("kubeconfig-save", defaultKubeconfig(), "Path to write kubeconfig file to")
+	infoSave       = flag.String("info-save", "", "Path to save info")
+	boskosURL      = flag.String("boskos-url", "http://boskos", "Boskos Server URL")
+	serviceAccount = flag.String("service-account", "", "Path to service account, application default credentials are used if not set")
+	tokenAuth      = flag.Bool("kubeconfig-token-auth", false, "Use an access token instead of the gcp auth provider in the kubeconfig")
 )
 
 type masonClient struct {
@@ -166,6 +168,14 @@ loop:
 				if err := res.UserData.Extract(gcp.ResourceConfigType, &info); err != nil {
 					logrus.WithError(err).Panicf("unable to parse %s", gcp.ResourceConfigType)
 				}
+				gcpClient, err := gcp.NewClient(*serviceAccount)
+				if err != nil {
+					logrus.WithError(err).Panic("unable to create gcp client")
+				}
+				if *tokenAuth {
+					gcpClient.UseTokenAuth()
+				}
+				gcp.SetClient(gcpClient)
 				if err := info.Install(*kubecfgPath); err != nil {
 					logrus.WithError(err).Panicf("unable to install %s", gcp.ResourceConfigType)
 				}
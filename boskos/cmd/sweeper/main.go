@@ -0,0 +1,87 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/test-infra/boskos/common"
+	"k8s.io/test-infra/boskos/crds"
+	"k8s.io/test-infra/boskos/ranch"
+
+	"istio.io/test-infra/boskos/gcp"
+)
+
+const (
+	defaultProjectType = "gcp-project"
+	// defaultGracePeriod leaves time for mason to record the instances it creates, which takes up to the operation
+	// timeout of a construction.
+	defaultGracePeriod = 2 * time.Hour
+)
+
+var (
+	namespace         = flag.String("namespace", corev1.NamespaceDefault, "Kubernetes namespace to query")
+	serviceAccount    = flag.String("service-account", "", "Path to projects service account")
+	projectType       = flag.String("project-type", defaultProjectType, "Type of the boskos resources of the projects to sweep")
+	gracePeriod       = flag.Duration("grace-period", defaultGracePeriod, "Age of the instances not recorded in the user data of their resource, whether leased or not, before they can be swept")
+	dryRun            = flag.Bool("dry-run", true, "Only log the instances that would be deleted")
+	kubeClientOptions crds.KubernetesClientOptions
+)
+
+func main() {
+	kubeClientOptions.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := kubeClientOptions.Validate(); err != nil {
+		logrus.WithError(err).Fatal("Bad kube client options")
+	}
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+
+	gcpClient, err := gcp.NewClient(*serviceAccount)
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to create gcp client")
+	}
+	kubeClient, err := kubeClientOptions.Client()
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to get kubernetes client")
+	}
+	st := ranch.NewStorage(context.Background(), kubeClient, *namespace)
+
+	resourceList, err := st.GetResources()
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to list boskos resources")
+	}
+	var (
+		resources []common.Resource
+		projects  []string
+	)
+	for _, r := range resourceList.Items {
+		res := r.ToResource()
+		resources = append(resources, res)
+		if res.Type == *projectType {
+			projects = append(projects, res.Name)
+		}
+	}
+	logrus.Infof("Sweeping %d projects of type %s", len(projects), *projectType)
+
+	if err := gcpClient.Sweep(context.Background(), projects, resources, *gracePeriod, *dryRun); err != nil {
+		logrus.WithError(err).Fatal("unable to sweep projects")
+	}
+	logrus.Info("Projects are swept")
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// bucketResource creates GCS buckets, in the location of their config or in the US multi-region by default. Buckets
+// are deleted along with their objects.
+type bucketResource struct {
+	service *storage.Service
+}
+
+func (b *bucketResource) validate(config kindConfig) error {
+	return checkOptions(config, "storageclass")
+}
+
+func (b *bucketResource) create(ctx context.Context, project string, config kindConfig) (*InstanceInfo, error) {
+	// Bucket names are global, and the generated names are unlikely to be taken.
+	name := generateName("gcs")
+	bucket := &storage.Bucket{
+		Name:         name,
+		Location:     config.Location,
+		StorageClass: config.Options["storageclass"],
+		Labels:       config.Labels,
+	}
+	if _, err := b.service.Buckets.Insert(project, bucket).Context(ctx).Do(); err != nil {
+		logrus.WithError(err).Errorf("failed to create bucket %s on project %s", name, project)
+		return nil, err
+	}
+	logrus.Infof("Bucket %s created on project %s", name, project)
+	return &InstanceInfo{Name: name, Zone: config.Location}, nil
+}
+
+func (b *bucketResource) delete(ctx context.Context, project string, info InstanceInfo) error {
+	// Buckets must be empty to be deleted, including the past versions of their objects.
+	err := b.service.Objects.List(info.Name).Versions(true).Pages(ctx, func(objects *storage.Objects) error {
+		for _, o := range objects.Items {
+			err := b.service.Objects.Delete(info.Name, o.Name).Generation(o.Generation).Context(ctx).Do()
+			if err != nil && !isNotFound(err) {
+				return fmt.Errorf("object %s: %v", o.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return b.service.Buckets.Delete(info.Name).Context(ctx).Do()
+}
+
+func (b *bucketResource) describe(ctx context.Context, project string, info InstanceInfo) (string, error) {
+	if _, err := b.service.Buckets.Get(info.Name).Context(ctx).Do(); err != nil {
+		return "", err
+	}
+	return "gs://" + info.Name, nil
+}
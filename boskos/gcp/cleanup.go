@@ -0,0 +1,172 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/test-infra/boskos/common"
+)
+
+const (
+	defaultRetryDelay = 30 * time.Second
+	deleteAttempts    = 3
+	// rollbackTimeoutFactor bounds the rollback of a construction, in operation timeouts: creations which are still
+	// running have to be done before their instance can be deleted.
+	rollbackTimeoutFactor = 2
+)
+
+// isNotFound checks if a GCP API error reports that the resource does not exist.
+func isNotFound(err error) bool {
+	e, ok := err.(*googleapi.Error)
+	return ok && e.Code == http.StatusNotFound
+}
+
+// retry calls fn until it succeeds, up to the given number of attempts, waiting delay between attempts. If attempts
+// is not positive, fn is called until it succeeds or the context is done. The last error of fn is returned.
+func retry(ctx context.Context, attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; attempts <= 0 || i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Cleanup deletes the clusters, vms and other resources recorded in the user data of a resource by a previous
+// Construct. Instances that no longer exist are considered deleted. Every instance is attempted, and the errors of
+// the instances that could not be deleted are aggregated.
+func Cleanup(ctx context.Context, res common.Resource) error {
+	if res.UserData == nil {
+		return nil
+	}
+
+	var info ResourceInfo
+	if err := res.UserData.Extract(ResourceConfigType, &info); err != nil {
+		if _, ok := err.(*common.UserDataNotFound); ok {
+			return nil
+		}
+		logrus.WithError(err).Errorf("unable to parse %s user data of %s", ResourceConfigType, res.Name)
+		return err
+	}
+
+	gcpClientLock.RLock()
+	client := gcpClient
+	gcpClientLock.RUnlock()
+
+	if client == nil {
+		err := fmt.Errorf("client not set")
+		logrus.WithError(err).Error("client not set; please call SetClient")
+		return err
+	}
+
+	return client.cleanup(ctx, res.Name, info)
+}
+
+// cleanup deletes the clusters, vms and other resources of a resource concurrently, retrying failed deletions.
+func (c *Client) cleanup(ctx context.Context, name string, info ResourceInfo) error {
+	ctx, cancel := context.WithTimeout(ctx, c.operationTimeout)
+	defer cancel()
+
+	return c.deleteInstances(ctx, name, info, deleteAttempts)
+}
+
+// rollback deletes the clusters, vms and other resources created by a failed construction. Their creation may still
+// be running, in which case their deletion fails until it is done, so failed deletions are retried until the rollback
+// times out.
+func (c *Client) rollback(name string, info ResourceInfo) error {
+	// The construction context may be done, so the rollback has its own.
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeoutFactor*c.operationTimeout)
+	defer cancel()
+
+	return c.deleteInstances(ctx, name, info, 0)
+}
+
+// deleteInstances deletes the instances of a resource concurrently, making up to the given number of attempts to
+// delete each of them. Retry until the context is done if attempts is not positive.
+func (c *Client) deleteInstances(ctx context.Context, name string, info ResourceInfo, attempts int) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+
+	deleteInstance := func(kind, project string, instance InstanceInfo, del func(context.Context, string, InstanceInfo) error) {
+		defer wg.Done()
+		err := retry(ctx, attempts, c.retryDelay, func() error {
+			err := del(ctx, project, instance)
+			if err != nil && isNotFound(err) {
+				logrus.Infof("%s %s in zone %s for project %s is already deleted", kind, instance.Name, instance.Zone, project)
+				return nil
+			}
+			if err != nil {
+				logrus.WithError(err).Warningf("failed to delete %s %s in zone %s for project %s", kind, instance.Name, instance.Zone, project)
+			}
+			return err
+		})
+		if err != nil {
+			logrus.WithError(err).Errorf("unable to delete %s %s in zone %s for project %s", kind, instance.Name, instance.Zone, project)
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("%s %s/%s/%s: %v", kind, project, instance.Zone, instance.Name, err))
+			mu.Unlock()
+			return
+		}
+		logrus.Infof("%s %s in zone %s for project %s is deleted", kind, instance.Name, instance.Zone, project)
+	}
+
+	for project, pi := range info {
+		for _, cluster := range pi.Clusters {
+			wg.Add(1)
+			go deleteInstance("cluster", project, cluster, c.gke.delete)
+		}
+		for _, vm := range pi.VMs {
+			wg.Add(1)
+			go deleteInstance("vm", project, vm, c.gce.delete)
+		}
+		for _, r := range pi.Resources {
+			kind, ok := c.kinds[r.Kind]
+			if !ok {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s %s/%s/%s: unknown kind", r.Kind, project, r.Zone, r.Name))
+				mu.Unlock()
+				continue
+			}
+			wg.Add(1)
+			go deleteInstance(r.Kind, project, r.InstanceInfo, kind.delete)
+		}
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to clean up resource %s: %v", name, utilerrors.NewAggregate(errs))
+	}
+	logrus.Infof("Resource %s is cleaned up", name)
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"k8s.io/test-infra/boskos/common"
+)
+
+func newUserData(t *testing.T, info ResourceInfo) *common.UserData {
+	ud := &common.UserData{}
+	if err := ud.Set(ResourceConfigType, &info); err != nil {
+		t.Fatalf("unable to set user data: %v", err)
+	}
+	return ud
+}
+
+func TestCleanup(t *testing.T) {
+	info := ResourceInfo{
+		"project1": {
+			Clusters: []InstanceInfo{{Name: "gke-1", Zone: "zone1"}, {Name: "gke-2", Zone: "zone2"}},
+			VMs:      []InstanceInfo{{Name: "gce-1", Zone: "zone1"}},
+		},
+		"project2": {
+			Clusters: []InstanceInfo{{Name: "gke-3", Zone: "zone3"}},
+		},
+	}
+
+	var testCases = []struct {
+		name             string
+		userData         *common.UserData
+		clusterFailures  int
+		vmFailures       int
+		expectedClusters []string
+		expectedVMs      []string
+		err              string
+	}{
+		{
+			name: "no user data",
+		},
+		{
+			name:     "no resource info",
+			userData: &common.UserData{},
+		},
+		{
+			name:             "success",
+			userData:         newUserData(t, info),
+			expectedClusters: []string{"project1/zone1/gke-1", "project1/zone2/gke-2", "project2/zone3/gke-3"},
+			expectedVMs:      []string{"project1/zone1/gce-1"},
+		},
+		{
+			name:             "retried failures",
+			userData:         newUserData(t, info),
+			clusterFailures:  deleteAttempts - 1,
+			vmFailures:       deleteAttempts - 1,
+			expectedClusters: []string{"project1/zone1/gke-1", "project1/zone2/gke-2", "project2/zone3/gke-3"},
+			expectedVMs:      []string{"project1/zone1/gce-1"},
+		},
+		{
+			name:             "persistent failure",
+			userData:         newUserData(t, info),
+			vmFailures:       deleteAttempts,
+			expectedClusters: []string{"project1/zone1/gke-1", "project1/zone2/gke-2", "project2/zone3/gke-3"},
+			err:              "failed to clean up resource test: vm project1/zone1/gce-1: fail",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gke := &fakeClusterCreator{fakeDeleter: fakeDeleter{failures: tc.clusterFailures}}
+			gce := &fakeVMCreator{fakeDeleter: fakeDeleter{failures: tc.vmFailures}}
+			SetClient(&Client{operationTimeout: time.Second, gke: gke, gce: gce})
+			defer SetClient(nil)
+
+			err := Cleanup(context.Background(), common.Resource{Name: "test", UserData: tc.userData})
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("expected err %s got %v", tc.err, err)
+				}
+			} else if err != nil {
+				t.Errorf("expected no error got %v", err)
+			}
+
+			sort.Strings(gke.deleted)
+			sort.Strings(gce.deleted)
+			if !reflect.DeepEqual(gke.deleted, tc.expectedClusters) {
+				t.Errorf("expected deleted clusters %v got %v", tc.expectedClusters, gke.deleted)
+			}
+			if !reflect.DeepEqual(gce.deleted, tc.expectedVMs) {
+				t.Errorf("expected deleted vms %v got %v", tc.expectedVMs, gce.deleted)
+			}
+		})
+	}
+}
+
+type notFoundClusterCreator struct {
+	fakeClusterCreator
+}
+
+func (cc *notFoundClusterCreator) delete(ctx context.Context, p string, i InstanceInfo) error {
+	return &googleapi.Error{Code: http.StatusNotFound}
+}
+
+func TestCleanupNotFound(t *testing.T) {
+	SetClient(&Client{operationTimeout: time.Second, gke: &notFoundClusterCreator{}, gce: &fakeVMCreator{}})
+	defer SetClient(nil)
+
+	ud := newUserData(t, ResourceInfo{"project": {Clusters: []InstanceInfo{{Name: "gke-1", Zone: "zone1"}}}})
+	if err := Cleanup(context.Background(), common.Resource{Name: "test", UserData: ud}); err != nil {
+		t.Errorf("expected deleted cluster not to fail cleanup, got %v", err)
+	}
+}
+
+func TestConstructCleansPreviousInstances(t *testing.T) {
+	gke := &fakeClusterCreator{}
+	gce := &fakeVMCreator{}
+	SetClient(&Client{operationTimeout: time.Second, gke: gke, gce: gce})
+	defer SetClient(nil)
+
+	rc := resourceConfigs{"test": {{Vms: []virtualMachineConfig{{}}}}}
+	res := common.Resource{
+		Name:     "test",
+		UserData: newUserData(t, ResourceInfo{"old": {VMs: []InstanceInfo{{Name: "gce-old", Zone: "zone1"}}}}),
+	}
+	types := common.TypeToResources{"test": []common.Resource{{Name: "leased"}}}
+
+	ud, err := rc.Construct(context.Background(), res, types)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	if expected := []string{"old/zone1/gce-old"}; !reflect.DeepEqual(gce.deleted, expected) {
+		t.Errorf("expected deleted vms %v got %v", expected, gce.deleted)
+	}
+
+	var info ResourceInfo
+	if err := ud.Extract(ResourceConfigType, &info); err != nil {
+		t.Fatalf("unable to parse user data %v", err)
+	}
+	if _, ok := info["old"]; ok || len(info["leased"].VMs) != 1 {
+		t.Errorf("expected user data to only hold the constructed vm, got %v", info)
+	}
+	if kubeconfig, ok := ud.Load(KubeConfigKey); !ok || strings.Contains(kubeconfig.(string), "gce-old") {
+		t.Errorf("expected kubeconfig user data to be set, got %v", kubeconfig)
+	}
+}
@@ -19,22 +19,28 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
-	"os"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/compute/v1"
 	container "google.golang.org/api/container/v1beta1"
 	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+	storage "google.golang.org/api/storage/v1"
 	yaml "gopkg.in/yaml.v2"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/test-infra/boskos/common"
 	"k8s.io/test-infra/boskos/mason"
 )
 
 var (
 	seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	// seededRand is used by concurrent creations, but is not safe for concurrent use.
+	seededRandLock sync.Mutex
 	// Setting client
 	gcpClient     *Client
 	gcpClientLock sync.RWMutex
@@ -46,21 +52,8 @@ const (
 	KubeConfigKey           = "kubeconfig"
 	defaultOperationTimeout = 15 * time.Minute
 	charset                 = "abcdefghijklmnopqrstuvwxyz1234567890"
-	maxChannels             = 100
 )
 
-func getTempFile(pattern string) (string, error) {
-	// Creating a kubeconfig will all information
-	f, err := ioutil.TempFile("", pattern)
-	if err != nil {
-		return "", err
-	}
-	if err = f.Close(); err != nil {
-		return "", err
-	}
-	return f.Name(), nil
-}
-
 // SetClient sets the gcpClient used to construct a ResourceConfig
 func SetClient(c *Client) {
 	gcpClientLock.Lock()
@@ -71,10 +64,24 @@ func SetClient(c *Client) {
 // NewClient creates a new client
 func NewClient(serviceAccount string) (*Client, error) {
 	ctx := context.Background() // TODO(fejta): move this into call signature
-	opts := []option.ClientOption{option.WithScopes(compute.CloudPlatformScope)}
+	var (
+		creds *google.Credentials
+		err   error
+	)
 	if serviceAccount != "" {
-		opts = append(opts, option.WithCredentialsFile(serviceAccount))
+		data, err := ioutil.ReadFile(serviceAccount)
+		if err != nil {
+			return nil, err
+		}
+		creds, err = google.CredentialsFromJSON(ctx, data, compute.CloudPlatformScope)
+		if err != nil {
+			return nil, err
+		}
+	} else if creds, err = google.FindDefaultCredentials(ctx, compute.CloudPlatformScope); err != nil {
+		return nil, err
 	}
+	// Sharing the token source between the services and the kubeconfigs.
+	opts := []option.ClientOption{option.WithTokenSource(creds.TokenSource)}
 	gkeService, err := container.NewService(ctx, opts...)
 	if err != nil {
 		return nil, err
@@ -83,50 +90,79 @@ func NewClient(serviceAccount string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	storageService, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	pubsubService, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
 	return &Client{
-		gke:              &containerEngine{gkeService},
+		gke:              &containerEngine{service: gkeService, tokenSource: creds.TokenSource},
 		gce:              &computeEngine{gceService},
+		kinds:            newResourceKinds(storageService, pubsubService, gceService),
+		tokenSource:      creds.TokenSource,
 		operationTimeout: defaultOperationTimeout,
+		retryDelay:       defaultRetryDelay,
 	}, nil
 }
 
 type projectConfig struct {
 	Clusters []clusterConfig        `json:"clusters,omitempty"`
 	Vms      []virtualMachineConfig `json:"vms,omitempty"`
+	// Resources are the resources of other kinds, such as buckets.
+	Resources []kindConfig `json:"resources,omitempty"`
+	Zones     *zonePolicy  `json:"zones,omitempty"`
 }
 
 // resourceConfigs is resource map of type of resource to list of project config
 type resourceConfigs map[string][]projectConfig
 
-// InstanceInfo stores information about a cluster or a vm instance
+// InstanceInfo stores information about a cluster or a vm instance. The zone of a regional cluster is its region.
 type InstanceInfo struct {
 	Name string `json:"name"`
 	Zone string `json:"zone"`
 }
 
-// ProjectInfo stores cluster and vm information for a given GCP project
+// ProjectInfo stores cluster, vm and other resource information for a given GCP project
 type ProjectInfo struct {
-	Clusters []InstanceInfo `json:"clusters,omitempty"`
-	VMs      []InstanceInfo `json:"vms,omitempty"`
+	Clusters  []InstanceInfo `json:"clusters,omitempty"`
+	VMs       []InstanceInfo `json:"vms,omitempty"`
+	Resources []KindInfo     `json:"resources,omitempty"`
 }
 
 // ResourceInfo holds information about the resource created, such that it can used
 type ResourceInfo map[string]ProjectInfo
 
+// vmCreator and clusterCreator create and delete instances. When the creation of an instance fails after it started,
+// the instance is returned along with the error, such that it can be deleted.
 type vmCreator interface {
 	create(context.Context, string, virtualMachineConfig) (*InstanceInfo, error)
+	delete(context.Context, string, InstanceInfo) error
 	listZones(project string) ([]string, error)
+	// list lists the vms of a project labeled with a boskos resource.
+	list(context.Context, string) ([]labeledInstance, error)
 }
 
 type clusterCreator interface {
 	create(context.Context, string, clusterConfig) (*InstanceInfo, error)
+	delete(context.Context, string, InstanceInfo) error
+	get(context.Context, string, InstanceInfo) (*container.Cluster, error)
+	// list lists the clusters of a project labeled with a boskos resource.
+	list(context.Context, string) ([]labeledInstance, error)
 }
 
 // Client abstracts operation with GCP
 type Client struct {
 	gke              clusterCreator
 	gce              vmCreator
+	kinds            map[string]resourceKind
+	tokenSource      oauth2.TokenSource
+	tokenAuth        bool
 	operationTimeout time.Duration
+	retryDelay       time.Duration
+	quota            quotaTracker
 }
 
 // used for communication between go routine
@@ -134,6 +170,7 @@ type com struct {
 	p   string
 	ci  *InstanceInfo
 	vmi *InstanceInfo
+	ki  *KindInfo
 }
 
 type stringRing struct {
@@ -151,31 +188,34 @@ func newStringRing(zones []string) *stringRing {
 	return &stringRing{values: zones}
 }
 
-// Construct implements Masonable interface
+// Construct implements Masonable interface. Instances created by a previous Construct of a dirty resource are
+// cleaned up first.
 func (rc resourceConfigs) Construct(ctx context.Context, res common.Resource, types common.TypeToResources) (*common.UserData, error) {
+	if err := Cleanup(ctx, res); err != nil {
+		logrus.WithError(err).Errorf("failed to clean up previous instances of %s", res.Name)
+	}
+
 	userData, info, err := rc.construct(ctx, res, types)
 	if err != nil {
 		return userData, err
 	}
 	// Creating a kubeconfig will all information
-	kubeconfig, err := getTempFile("kubeconfig")
+	kubeconfig, err := gcpClient.kubeConfig(ctx, *info)
 	if err != nil {
-		return nil, err
-	}
-	defer os.Remove(kubeconfig)
-
-	if err := info.Install(kubeconfig); err != nil {
-		return nil, err
+		logrus.WithError(err).Errorf("failed to create kubeconfig for %s", res.Name)
+		return nil, rollback(res.Name, *info, err)
 	}
-	data, err := ioutil.ReadFile(kubeconfig)
-	if err != nil {
-		return nil, err
+	var data []byte
+	if len(kubeconfig.Contexts) > 0 {
+		if data, err = marshalKubeConfig(kubeconfig); err != nil {
+			return nil, rollback(res.Name, *info, err)
+		}
 	}
 	// Saving kubeconfig info in user data
 	dataStr := string(data)
 	if err := userData.Set(KubeConfigKey, &dataStr); err != nil {
 		logrus.WithError(err).Errorf("unable to set %s user data", KubeConfigKey)
-		return nil, err
+		return nil, rollback(res.Name, *info, err)
 	}
 	return userData, nil
 }
@@ -189,7 +229,15 @@ func (rc resourceConfigs) construct(ctx context.Context, res common.Resource, ty
 		return nil, nil, err
 	}
 
-	communication := make(chan com, maxChannels)
+	// Each piece sends at most one message, so the pieces never block on a channel that is only read once they are
+	// all done.
+	var pieces int
+	for _, pcs := range rc {
+		for _, pc := range pcs {
+			pieces += len(pc.Clusters) + len(pc.Vms) + len(pc.Resources)
+		}
+	}
+	communication := make(chan com, pieces)
 
 	// Copy
 	typesCopy := types
@@ -207,59 +255,137 @@ func (rc resourceConfigs) construct(ctx context.Context, res common.Resource, ty
 	defer cancel()
 	errGroup, derivedCtx := errgroup.WithContext(ctx)
 
-	// Here we know that resources are of project type
-	for rType, pcs := range rc {
-		for _, pc := range pcs {
-			project := popProject(rType)
-
-			if project == nil {
-				err = fmt.Errorf("running out of project while creating resources")
-				logrus.WithError(err).Errorf("unable to create resources")
-				return nil, nil, err
-			}
-			zones, err := gcpClient.gce.listZones(project.Name)
-			if err != nil {
-				return nil, nil, err
-			}
-			zoneRing := newStringRing(zones)
+	// failures records the pieces that failed to be created. Pieces interrupted because another piece failed, or
+	// because scheduling failed, are not reported, whichever error the interruption surfaces as. Pieces interrupted by
+	// the timeout are.
+	var (
+		failures     []error
+		failuresLock sync.Mutex
+	)
+	fail := func(piece string, err error) error {
+		if derivedCtx.Err() == nil || ctx.Err() == context.DeadlineExceeded {
+			failuresLock.Lock()
+			failures = append(failures, fmt.Errorf("%s: %v", piece, err))
+			failuresLock.Unlock()
+		}
+		return err
+	}
 
-			for i := range pc.Clusters {
-				cl := pc.Clusters[i]
-				if cl.Zone == "" {
-					cl.Zone = zoneRing.next()
+	labels := resourceLabels(res, time.Now())
+	schedule := func() error {
+		// Here we know that resources are of project type
+		for rType, pcs := range rc {
+			for _, pc := range pcs {
+				project := popProject(rType)
+
+				if project == nil {
+					err := fmt.Errorf("running out of project while creating resources")
+					logrus.WithError(err).Errorf("unable to create resources")
+					return err
 				}
-				errGroup.Go(func() error {
-					clusterInfo, err := gcpClient.gke.create(derivedCtx, project.Name, cl)
-					if err != nil {
-						logrus.WithError(err).Errorf("unable to create cluster on project %s", project.Name)
-						return err
+				var unplaced int
+				for _, cl := range pc.Clusters {
+					if cl.Zone == "" {
+						unplaced++
+					}
+				}
+				for _, vm := range pc.Vms {
+					if vm.Zone == "" {
+						unplaced++
 					}
-					communication <- com{p: project.Name, ci: clusterInfo}
-					return nil
-				})
-			}
-			for j := range pc.Vms {
-				vm := pc.Vms[j]
-				if vm.Zone == "" {
-					vm.Zone = zoneRing.next()
 				}
-				errGroup.Go(func() error {
-					vmInfo, err := gcpClient.gce.create(derivedCtx, project.Name, vm)
-					if err != nil {
-						logrus.WithError(err).Errorf("unable to create vm on project %s", project.Name)
+				var zoneRing *stringRing
+				if unplaced > 0 {
+					var err error
+					if zoneRing, err = gcpClient.pickZones(project.Name, pc.Zones, unplaced); err != nil {
 						return err
 					}
-					communication <- com{p: project.Name, vmi: vmInfo}
-					return nil
-				})
+				}
+
+				for i := range pc.Clusters {
+					i, cl := i, pc.Clusters[i]
+					if cl.Zone == "" {
+						cl.Zone = zoneRing.next()
+					}
+					if cl.Regional {
+						cl.Zone = regionOf(cl.Zone)
+					}
+					cl.Labels = withLabels(cl.Labels, labels)
+					errGroup.Go(func() error {
+						clusterInfo, err := gcpClient.gke.create(derivedCtx, project.Name, cl)
+						gcpClient.quota.record(project.Name, cl.Zone, err)
+						if err != nil {
+							logrus.WithError(err).Errorf("unable to create cluster on project %s", project.Name)
+							if clusterInfo != nil {
+								// Rolling back the cluster, which may exist.
+								communication <- com{p: project.Name, ci: clusterInfo}
+							}
+							return fail(fmt.Sprintf("cluster %d on project %s", i, project.Name), err)
+						}
+						communication <- com{p: project.Name, ci: clusterInfo}
+						return nil
+					})
+				}
+				for j := range pc.Vms {
+					j, vm := j, pc.Vms[j]
+					if vm.Zone == "" {
+						vm.Zone = zoneRing.next()
+					}
+					vm.Labels = withLabels(vm.Labels, labels)
+					errGroup.Go(func() error {
+						vmInfo, err := gcpClient.gce.create(derivedCtx, project.Name, vm)
+						gcpClient.quota.record(project.Name, vm.Zone, err)
+						if err != nil {
+							logrus.WithError(err).Errorf("unable to create vm on project %s", project.Name)
+							if vmInfo != nil {
+								// Rolling back the vm, which may exist.
+								communication <- com{p: project.Name, vmi: vmInfo}
+							}
+							return fail(fmt.Sprintf("vm %d on project %s", j, project.Name), err)
+						}
+						communication <- com{p: project.Name, vmi: vmInfo}
+						return nil
+					})
+				}
+				for k := range pc.Resources {
+					k, kc := k, pc.Resources[k]
+					kind, ok := gcpClient.kinds[kc.Kind]
+					if !ok {
+						return fmt.Errorf("unknown kind %q of resource %d on project %s", kc.Kind, k, project.Name)
+					}
+					kc.Labels = withLabels(kc.Labels, labels)
+					errGroup.Go(func() error {
+						piece := fmt.Sprintf("%s %d on project %s", kc.Kind, k, project.Name)
+						info, err := kind.create(derivedCtx, project.Name, kc)
+						if err != nil {
+							logrus.WithError(err).Errorf("unable to create %s on project %s", kc.Kind, project.Name)
+							if info != nil {
+								// Rolling back the resource, which may exist.
+								communication <- com{p: project.Name, ki: &KindInfo{Kind: kc.Kind, InstanceInfo: *info}}
+							}
+							return fail(piece, err)
+						}
+						ki := &KindInfo{Kind: kc.Kind, InstanceInfo: *info}
+						ki.Description, err = kind.describe(derivedCtx, project.Name, *info)
+						communication <- com{p: project.Name, ki: ki}
+						if err != nil {
+							logrus.WithError(err).Errorf("unable to describe %s %s on project %s", kc.Kind, info.Name, project.Name)
+							return fail(piece, err)
+						}
+						return nil
+					})
+				}
 			}
 		}
-
+		return nil
 	}
-	if err := errGroup.Wait(); err != nil {
-		logrus.WithError(err).Errorf("failed to construct resources for %s", res.Name)
-		return nil, nil, err
+
+	scheduleErr := schedule()
+	if scheduleErr != nil {
+		// Interrupting the pieces already being created, which are rolled back.
+		cancel()
 	}
+	err = errGroup.Wait()
 	close(communication)
 
 	info := ResourceInfo{}
@@ -269,23 +395,50 @@ func (rc resourceConfigs) construct(ctx context.Context, res common.Resource, ty
 		if !exists {
 			pi = ProjectInfo{}
 		}
-		if c.ci != nil {
+		switch {
+		case c.ci != nil:
 			pi.Clusters = append(pi.Clusters, *c.ci)
-		} else {
+		case c.vmi != nil:
 			pi.VMs = append(pi.VMs, *c.vmi)
+		default:
+			pi.Resources = append(pi.Resources, *c.ki)
 		}
 		info[c.p] = pi
 	}
 
+	if scheduleErr != nil {
+		return nil, nil, rollback(res.Name, info, scheduleErr)
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("failed to construct resources for %s", res.Name)
+		if len(failures) > 0 {
+			err = fmt.Errorf("failed to construct resources for %s: %v", res.Name, utilerrors.NewAggregate(failures))
+		}
+		return nil, nil, rollback(res.Name, info, err)
+	}
+
 	userData := common.UserData{}
 	if err := userData.Set(ResourceConfigType, &info); err != nil {
 		logrus.WithError(err).Errorf("unable to set %s user data", ResourceConfigType)
-		return nil, nil, err
+		return nil, nil, rollback(res.Name, info, err)
 	}
 
 	return &userData, &info, nil
 }
 
+// rollback deletes the instances created by a failed construction, adding the instances that could not be deleted
+// to the construction error.
+func rollback(name string, info ResourceInfo, err error) error {
+	if len(info) == 0 {
+		return err
+	}
+	logrus.Infof("Rolling back resources constructed for %s", name)
+	if rollbackErr := gcpClient.rollback(name, info); rollbackErr != nil {
+		return fmt.Errorf("%v; rollback failed: %v", err, rollbackErr)
+	}
+	return err
+}
+
 // ConfigConverter implements mason.ConfigConverter
 func ConfigConverter(in string) (mason.Masonable, error) {
 	var config resourceConfigs
@@ -293,10 +446,37 @@ func ConfigConverter(in string) (mason.Masonable, error) {
 		logrus.WithError(err).Errorf("unable to parse %s", in)
 		return nil, err
 	}
+	for rType, pcs := range config {
+		for i, pc := range pcs {
+			for j, cl := range pc.Clusters {
+				if err := cl.validate(); err != nil {
+					err = fmt.Errorf("invalid cluster %d of project %d of %s: %v", j, i, rType, err)
+					logrus.WithError(err).Errorf("unable to parse %s", in)
+					return nil, err
+				}
+			}
+			for j, vm := range pc.Vms {
+				if err := vm.validate(); err != nil {
+					err = fmt.Errorf("invalid vm %d of project %d of %s: %v", j, i, rType, err)
+					logrus.WithError(err).Errorf("unable to parse %s", in)
+					return nil, err
+				}
+			}
+			for j, kc := range pc.Resources {
+				if err := validateKindConfig(kc); err != nil {
+					err = fmt.Errorf("invalid resource %d of project %d of %s: %v", j, i, rType, err)
+					logrus.WithError(err).Errorf("unable to parse %s", in)
+					return nil, err
+				}
+			}
+		}
+	}
 	return &config, nil
 }
 
 func randomString(length int) string {
+	seededRandLock.Lock()
+	defer seededRandLock.Unlock()
 	b := make([]byte, length)
 	for i := range b {
 		b[i] = charset[seededRand.Intn(len(charset))]
@@ -309,16 +489,3 @@ func generateName(prefix string) string {
 	randString := randomString(10)
 	return fmt.Sprintf("%s-%s-%s", prefix, date, randString)
 }
-
-// Install kubeconfig for a given resource. It will create only one file with all contexts.
-func (r ResourceInfo) Install(kubeconfig string) error {
-	for project, p := range r {
-		for _, c := range p.Clusters {
-			if err := SetKubeConfig(project, c.Zone, c.Name, kubeconfig); err != nil {
-				logrus.WithError(err).Errorf("failed to set kubeconfig")
-				return err
-			}
-		}
-	}
-	return nil
-}
@@ -16,9 +16,12 @@ package gcp
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"math"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -153,11 +156,16 @@ func TestParseConfig(t *testing.T) {
 type faker struct {
 	fail     bool
 	waitTime time.Duration
+	// wrap wraps the error of a done context, as clients do.
+	wrap bool
 }
 
 func (f faker) do(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
+		if f.wrap {
+			return fmt.Errorf("operation interrupted: %v", ctx.Err())
+		}
 		return ctx.Err()
 	case <-time.After(f.waitTime):
 	}
@@ -167,7 +175,30 @@ func (f faker) do(ctx context.Context) error {
 	return nil
 }
 
+// fakeDeleter records deleted instances, failing the first failures deletions.
+type fakeDeleter struct {
+	mu       sync.Mutex
+	failures int
+	deleted  []string
+}
+
+func (d *fakeDeleter) delete(ctx context.Context, p string, i InstanceInfo) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failures > 0 {
+		d.failures--
+		return fmt.Errorf("fail")
+	}
+	d.deleted = append(d.deleted, fmt.Sprintf("%s/%s/%s", p, i.Zone, i.Name))
+	return nil
+}
+
+func (d *fakeDeleter) list(ctx context.Context, p string) ([]labeledInstance, error) {
+	return nil, nil
+}
+
 type fakeVMCreator struct {
+	fakeDeleter
 	f *faker
 }
 
@@ -184,10 +215,11 @@ func (vmc *fakeVMCreator) create(ctx context.Context, p string, c virtualMachine
 }
 
 func (vmc *fakeVMCreator) listZones(project string) ([]string, error) {
-	return []string{"zone1", "zone2", "zone3"}, nil
+	return []string{"us-central1-a", "us-central1-b", "us-central1-c"}, nil
 }
 
 type fakeClusterCreator struct {
+	fakeDeleter
 	f *faker
 }
 
@@ -203,6 +235,14 @@ func (cc *fakeClusterCreator) create(ctx context.Context, p string, c clusterCon
 	}, nil
 }
 
+func (cc *fakeClusterCreator) get(ctx context.Context, p string, i InstanceInfo) (*container.Cluster, error) {
+	return &container.Cluster{
+		Name:       i.Name,
+		Endpoint:   "1.2.3.4",
+		MasterAuth: &container.MasterAuth{ClusterCaCertificate: base64.StdEncoding.EncodeToString([]byte("ca"))},
+	}, nil
+}
+
 func sortInfo(info *ResourceInfo) {
 	for _, v := range *info {
 		sort.Slice(v.Clusters, func(i, j int) bool { return v.Clusters[i].Zone < v.Clusters[j].Zone })
@@ -214,6 +254,8 @@ func TestResourcesConfig_Construct(t *testing.T) {
 	type expected struct {
 		err  string
 		info *ResourceInfo
+		// deletedClusters and deletedVMs are the number of instances rolled back.
+		deletedClusters, deletedVMs int
 	}
 
 	var testCases = []struct {
@@ -268,11 +310,11 @@ func TestResourcesConfig_Construct(t *testing.T) {
 							},
 							{
 								Name: "name",
-								Zone: "zone1",
+								Zone: "us-central1-a",
 							},
 							{
 								Name: "name",
-								Zone: "zone2",
+								Zone: "us-central1-b",
 							},
 						},
 						VMs: []InstanceInfo{
@@ -282,11 +324,11 @@ func TestResourcesConfig_Construct(t *testing.T) {
 							},
 							{
 								Name: "name",
-								Zone: "zone1",
+								Zone: "us-central1-a",
 							},
 							{
 								Name: "name",
-								Zone: "zone3",
+								Zone: "us-central1-c",
 							},
 						},
 					},
@@ -315,7 +357,8 @@ func TestResourcesConfig_Construct(t *testing.T) {
 				},
 			},
 			result: expected{
-				err: "context deadline exceeded",
+				err:             "failed to construct resources for test: vm 0 on project leased: context deadline exceeded",
+				deletedClusters: 1,
 			},
 			vmf: &faker{
 				waitTime: 2 * time.Second,
@@ -343,7 +386,8 @@ func TestResourcesConfig_Construct(t *testing.T) {
 				},
 			},
 			result: expected{
-				err: "context deadline exceeded",
+				err:        "failed to construct resources for test: cluster 0 on project leased: context deadline exceeded",
+				deletedVMs: 1,
 			},
 			cf: &faker{
 				waitTime: 2 * time.Second,
@@ -371,7 +415,8 @@ func TestResourcesConfig_Construct(t *testing.T) {
 				},
 			},
 			result: expected{
-				err: "fail",
+				err:             "failed to construct resources for test: vm 0 on project leased: fail",
+				deletedClusters: 1,
 			},
 			vmf: &faker{
 				fail: true,
@@ -399,11 +444,70 @@ func TestResourcesConfig_Construct(t *testing.T) {
 				},
 			},
 			result: expected{
-				err: "fail",
+				err:        "failed to construct resources for test: cluster 0 on project leased: fail",
+				deletedVMs: 1,
+			},
+			cf: &faker{
+				fail: true,
+			},
+		},
+		{
+			name:      "failed cluster creation interrupting vm creation",
+			setClient: true,
+			rc: resourceConfigs{
+				"test": {{
+					Clusters: []clusterConfig{
+						{},
+					},
+					Vms: []virtualMachineConfig{
+						{},
+					}},
+				},
+			},
+			res: common.Resource{
+				Name: "test",
+			},
+			types: common.TypeToResources{
+				"test": []common.Resource{
+					{Name: "leased"},
+				},
+			},
+			result: expected{
+				err: "failed to construct resources for test: cluster 0 on project leased: fail",
 			},
 			cf: &faker{
 				fail: true,
 			},
+			vmf: &faker{
+				waitTime: 2 * time.Second,
+				wrap:     true,
+			},
+		},
+		{
+			name:      "timeout wrapped vm creation",
+			setClient: true,
+			rc: resourceConfigs{
+				"test": {{
+					Vms: []virtualMachineConfig{
+						{},
+					}},
+				},
+			},
+			res: common.Resource{
+				Name: "test",
+			},
+			types: common.TypeToResources{
+				"test": []common.Resource{
+					{Name: "leased"},
+				},
+			},
+			result: expected{
+				err: "failed to construct resources for test: vm 0 on project leased: operation interrupted: context deadline exceeded",
+			},
+			vmf: &faker{
+				waitTime: 2 * time.Second,
+				wrap:     true,
+			},
 		},
 		{
 			name:      "running out project",
@@ -456,11 +560,13 @@ func TestResourcesConfig_Construct(t *testing.T) {
 		},
 	}
 	for _, tc := range testCases {
+		gce := &fakeVMCreator{f: tc.vmf}
+		gke := &fakeClusterCreator{f: tc.cf}
 		if tc.setClient {
 			c := &Client{
 				operationTimeout: time.Second,
-				gce:              &fakeVMCreator{f: tc.vmf},
-				gke:              &fakeClusterCreator{f: tc.cf},
+				gce:              gce,
+				gke:              gke,
 			}
 			SetClient(c)
 		} else {
@@ -474,6 +580,10 @@ func TestResourcesConfig_Construct(t *testing.T) {
 			if err == nil || err.Error() != tc.result.err {
 				t.Errorf("%s - expected err %s got %v", tc.name, tc.result.err, err)
 			}
+			if len(gke.deleted) != tc.result.deletedClusters || len(gce.deleted) != tc.result.deletedVMs {
+				t.Errorf("%s - expected %d clusters and %d vms rolled back got %v and %v", tc.name,
+					tc.result.deletedClusters, tc.result.deletedVMs, gke.deleted, gce.deleted)
+			}
 		} else {
 			if err != nil {
 				t.Errorf("%s - expected no error got %v", tc.name, err)
@@ -493,3 +603,93 @@ func TestResourcesConfig_Construct(t *testing.T) {
 		}
 	}
 }
+
+func TestResourcesConfig_ConstructManyPieces(t *testing.T) {
+	gce := &fakeVMCreator{}
+	SetClient(&Client{operationTimeout: time.Second, gce: gce, gke: &fakeClusterCreator{}})
+	defer SetClient(nil)
+
+	// More pieces than used to be buffered, which blocked the pieces created last.
+	rc := resourceConfigs{"test": {{Vms: make([]virtualMachineConfig, 150)}}}
+	types := common.TypeToResources{"test": []common.Resource{{Name: "leased"}}}
+
+	done := make(chan error, 1)
+	go func() {
+		_, info, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types)
+		if err == nil && len((*info)["leased"].VMs) != 150 {
+			err = fmt.Errorf("expected 150 vms got %d", len((*info)["leased"].VMs))
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("construct did not complete")
+	}
+}
+
+func TestResourcesConfig_ConstructRollback(t *testing.T) {
+	var testCases = []struct {
+		name                        string
+		rc                          resourceConfigs
+		vmf                         *faker
+		clusterDeleteFailures       int
+		err                         string
+		deletedClusters, deletedVMs []string
+	}{
+		{
+			name: "running out of project after scheduling",
+			rc: resourceConfigs{
+				"test": {
+					{Clusters: []clusterConfig{{}}, Vms: []virtualMachineConfig{{}}},
+					{Clusters: []clusterConfig{{}}},
+				},
+			},
+			err:             "running out of project while creating resources",
+			deletedClusters: []string{"leased/us-central1-a/name"},
+			deletedVMs:      []string{"leased/us-central1-b/name"},
+		},
+		{
+			name: "rollback retried until the deletions succeed",
+			rc: resourceConfigs{
+				"test": {{Clusters: []clusterConfig{{}}, Vms: []virtualMachineConfig{{}}}},
+			},
+			vmf:                   &faker{fail: true},
+			clusterDeleteFailures: 2 * deleteAttempts,
+			err:                   "failed to construct resources for test: vm 0 on project leased: fail",
+			deletedClusters:       []string{"leased/us-central1-a/name"},
+		},
+		{
+			name: "failed rollback",
+			rc: resourceConfigs{
+				"test": {{Clusters: []clusterConfig{{}}, Vms: []virtualMachineConfig{{}}}},
+			},
+			vmf:                   &faker{fail: true},
+			clusterDeleteFailures: math.MaxInt32,
+			err: "failed to construct resources for test: vm 0 on project leased: fail; " +
+				"rollback failed: failed to clean up resource test: cluster leased/us-central1-a/name: fail",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gce := &fakeVMCreator{f: tc.vmf}
+			gke := &fakeClusterCreator{fakeDeleter: fakeDeleter{failures: tc.clusterDeleteFailures}}
+			SetClient(&Client{operationTimeout: 100 * time.Millisecond, retryDelay: time.Millisecond, gce: gce, gke: gke})
+			defer SetClient(nil)
+
+			types := common.TypeToResources{"test": []common.Resource{{Name: "leased"}}}
+			_, _, err := tc.rc.construct(context.Background(), common.Resource{Name: "test"}, types)
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("expected err %s got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(gke.deleted, tc.deletedClusters) || !reflect.DeepEqual(gce.deleted, tc.deletedVMs) {
+				t.Errorf("expected clusters %v and vms %v rolled back got %v and %v", tc.deletedClusters, tc.deletedVMs, gke.deleted, gce.deleted)
+			}
+		})
+	}
+}
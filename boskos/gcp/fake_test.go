@@ -0,0 +1,282 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/test-infra/boskos/common"
+)
+
+func TestFakeGCPOperationLifecycle(t *testing.T) {
+	now := time.Now()
+	f := NewFakeGCP()
+	f.OperationDuration = time.Minute
+	f.now = func() time.Time { return now }
+
+	op, err := f.insert(context.Background(), FakeInstance{Kind: FakeVM, Project: "p", Zone: "us-central1-a", Name: "vm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(at string, wantOp, wantInstance string) {
+		t.Helper()
+		status, _, err := f.operation(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != wantOp {
+			t.Errorf("%s: expected operation %s, got %s", at, wantOp, status)
+		}
+		instances := f.Instances()
+		switch {
+		case wantInstance == "" && len(instances) != 0:
+			t.Errorf("%s: expected no instance, got %v", at, instances)
+		case wantInstance != "" && (len(instances) != 1 || instances[0].Status != wantInstance):
+			t.Errorf("%s: expected instance %s, got %v", at, wantInstance, instances)
+		}
+	}
+
+	check("created", operationPending, instanceProvisioning)
+	now = now.Add(30 * time.Second)
+	check("half way", operationRunning, instanceProvisioning)
+	now = now.Add(30 * time.Second)
+	check("done", operationDone, instanceRunning)
+
+	op, err = f.remove(context.Background(), FakeVM, "p", InstanceInfo{Name: "vm", Zone: "us-central1-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("deleting", operationPending, instanceStopping)
+	if _, err := f.remove(context.Background(), FakeVM, "p", InstanceInfo{Name: "vm", Zone: "us-central1-a"}); !isNotFound(err) {
+		t.Errorf("expected instance being deleted not to be found, got %v", err)
+	}
+	now = now.Add(time.Minute)
+	check("deleted", operationDone, "")
+}
+
+func TestFakeGCPClusterVersion(t *testing.T) {
+	f := NewFakeGCP()
+	f.DefaultVersion = "1.14.10-gke.27"
+	f.ValidVersions = []string{"1.16.6-gke.12", "1.15.9-gke.24", "1.14.10-gke.27"}
+	gke := &fakeContainerEngine{f}
+
+	var versions []string
+	for _, v := range []string{"", "1.15", "1.16.6-gke.12", "1.99"} {
+		if _, err := gke.create(context.Background(), "p", clusterConfig{Zone: "us-central1-a", Version: v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, i := range f.Instances() {
+		versions = append(versions, i.Version)
+	}
+	expected := []string{"1.14.10-gke.27", "1.14.10-gke.27", "1.15.9-gke.24", "1.16.6-gke.12"}
+	sort.Strings(versions)
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected versions %v, got %v", expected, versions)
+	}
+}
+
+func TestFakeGCPFailures(t *testing.T) {
+	injected := fmt.Errorf("injected")
+	var testCases = []struct {
+		name     string
+		failures []FakeFailure
+		// calls is the number of vm creations in zone us-central1-a of project p.
+		calls    int
+		zone     string
+		errs     []string
+		expected int
+	}{
+		{
+			name:     "no failure",
+			calls:    2,
+			errs:     []string{"", ""},
+			expected: 2,
+		},
+		{
+			name:     "failing once",
+			failures: []FakeFailure{{Method: FakeCreate, Kind: FakeVM, Times: 1, Err: injected}},
+			calls:    2,
+			errs:     []string{"injected", ""},
+			expected: 1,
+		},
+		{
+			name:     "failing every call",
+			failures: []FakeFailure{{Method: FakeCreate, Err: injected}},
+			calls:    2,
+			errs:     []string{"injected", "injected"},
+		},
+		{
+			name:     "aborted operation",
+			failures: []FakeFailure{{Method: FakeCreate, Operation: true, Times: 1, Err: injected}},
+			calls:    2,
+			errs:     []string{"injected", ""},
+			expected: 1,
+		},
+		{
+			name:     "failure in another project",
+			failures: []FakeFailure{{Project: "other", Err: injected}},
+			calls:    1,
+			errs:     []string{""},
+			expected: 1,
+		},
+		{
+			name:  "unknown zone",
+			zone:  "mars-north1-a",
+			calls: 1,
+			errs:  []string{"googleapi: Error 404: location mars-north1-a not found"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := NewFakeGCP()
+			f.Fail(tc.failures...)
+			gce := &fakeComputeEngine{f}
+			zone := tc.zone
+			if zone == "" {
+				zone = "us-central1-a"
+			}
+			for i := 0; i < tc.calls; i++ {
+				_, err := gce.create(context.Background(), "p", virtualMachineConfig{Zone: zone})
+				var errString string
+				if err != nil {
+					errString = err.Error()
+				}
+				if errString != tc.errs[i] {
+					t.Errorf("call %d: expected error %q, got %q", i, tc.errs[i], errString)
+				}
+			}
+			if instances := f.Instances(); len(instances) != tc.expected {
+				t.Errorf("expected %d instances, got %v", tc.expected, instances)
+			}
+		})
+	}
+}
+
+func TestFakeGCPListZones(t *testing.T) {
+	f := NewFakeGCP()
+	f.Zones = []string{"us-west1-a", "us-east1-b"}
+	gce := &fakeComputeEngine{f}
+	zones, err := gce.listZones("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(zones, f.Zones) {
+		t.Errorf("expected zones %v, got %v", f.Zones, zones)
+	}
+
+	f.Fail(FakeFailure{Method: FakeListZones, Project: "p"})
+	if _, err := gce.listZones("p"); err == nil {
+		t.Error("expected listing zones to fail")
+	}
+}
+
+func TestFakeGCPLatency(t *testing.T) {
+	f := NewFakeGCP()
+	f.Latency = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	gce := &fakeComputeEngine{f}
+	if _, err := gce.create(ctx, "p", virtualMachineConfig{Zone: "us-central1-a"}); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if instances := f.Instances(); len(instances) != 0 {
+		t.Errorf("expected no instance, got %v", instances)
+	}
+}
+
+func TestConstructWithFakeGCP(t *testing.T) {
+	f := NewFakeGCP()
+	f.OperationDuration = 50 * time.Millisecond
+	f.Latency = 10 * time.Millisecond
+	SetClient(NewFakeClient(f))
+
+	rc := resourceConfigs{
+		"project": {{
+			Clusters: []clusterConfig{{Version: "1.15"}, {Zone: "us-west1-a"}},
+			Vms:      []virtualMachineConfig{{}},
+		}},
+	}
+	types := func() common.TypeToResources {
+		return common.TypeToResources{"project": {{Name: "p"}}}
+	}
+
+	// The vm fails once its operation is over, after the clusters are created.
+	f.Fail(FakeFailure{Method: FakeCreate, Kind: FakeVM, Operation: true, Times: 1, Err: fmt.Errorf("quota exceeded")})
+	if _, _, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types()); err == nil {
+		t.Fatal("expected construct to fail")
+	} else if expected := "failed to construct resources for test: vm 0 on project p: quota exceeded"; err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err)
+	}
+	if instances := f.Instances(); len(instances) != 0 {
+		t.Errorf("expected instances to be rolled back, got %v", instances)
+	}
+
+	_, info, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, i := range f.Instances() {
+		if i.Status != instanceRunning {
+			t.Errorf("expected %s %s to be running, got %s", i.Kind, i.Name, i.Status)
+		}
+		if i.Kind == FakeCluster {
+			versions = append(versions, i.Version)
+		}
+	}
+	sort.Strings(versions)
+	if expected := []string{"1.14.10-gke.27", "1.15.9-gke.24"}; !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected cluster versions %v, got %v", expected, versions)
+	}
+	if pi := (*info)["p"]; len(pi.Clusters) != 2 || len(pi.VMs) != 1 {
+		t.Errorf("expected 2 clusters and a vm, got %v", *info)
+	}
+
+	if err := NewFakeClient(f).cleanup(context.Background(), "test", *info); err != nil {
+		t.Fatal(err)
+	}
+	if instances := f.Instances(); len(instances) != 0 {
+		t.Errorf("expected instances to be deleted, got %v", instances)
+	}
+}
+
+func TestConstructRollbackWithFakeGCP(t *testing.T) {
+	f := NewFakeGCP()
+	f.OperationDuration = 100 * time.Millisecond
+	SetClient(NewFakeClient(f))
+	defer SetClient(nil)
+
+	rc := resourceConfigs{"project": {{Clusters: []clusterConfig{{}}, Vms: []virtualMachineConfig{{}}}}}
+	types := common.TypeToResources{"project": {{Name: "p"}}}
+
+	// The vm fails right away, interrupting the creation of the cluster, which can only be deleted once created.
+	f.Fail(FakeFailure{Method: FakeCreate, Kind: FakeVM, Times: 1, Err: fmt.Errorf("quota exceeded")})
+	if _, _, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types); err == nil {
+		t.Fatal("expected construct to fail")
+	} else if expected := "failed to construct resources for test: vm 0 on project p: quota exceeded"; err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err)
+	}
+	if instances := f.Instances(); len(instances) != 0 {
+		t.Errorf("expected instances to be rolled back, got %v", instances)
+	}
+}
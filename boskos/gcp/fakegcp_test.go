@@ -0,0 +1,553 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	container "google.golang.org/api/container/v1beta1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// Methods of the fake GCP APIs in which failures can be injected.
+	FakeCreate       = "create"
+	FakeDelete       = "delete"
+	FakeGet          = "get"
+	FakeList         = "list"
+	FakeListZones    = "listZones"
+	FakeServerConfig = "serverConfig"
+
+	// Kinds of instances held by the fake GCP APIs.
+	FakeCluster = "cluster"
+	FakeVM      = "vm"
+
+	// Defined in https://godoc.org/google.golang.org/api/container/v1#Operation
+	operationPending = "PENDING"
+	operationRunning = "RUNNING"
+
+	instanceProvisioning = "PROVISIONING"
+	instanceRunning      = "RUNNING"
+	instanceStopping     = "STOPPING"
+
+	defaultFakePollInterval = 10 * time.Millisecond
+	// FakeToken is the access token of the clients of fake GCP APIs.
+	FakeToken = "fake-token"
+)
+
+var (
+	defaultFakeZones    = []string{"us-central1-a", "us-central1-b", "us-central1-c", "us-east1-b", "us-west1-a"}
+	defaultFakeVersions = []string{"1.16.6-gke.12", "1.15.9-gke.24", "1.14.10-gke.27"}
+)
+
+// FakeFailure is an error injected into the fake GCP APIs. Empty fields match any call.
+type FakeFailure struct {
+	// Method is one of FakeCreate, FakeDelete, FakeGet, FakeList, FakeListZones and FakeServerConfig.
+	Method string
+	// Kind is either FakeCluster, FakeVM or the kind of another resource, such as bucket.
+	Kind    string
+	Project string
+	Zone    string
+	// Operation lets the call succeed and aborts the operation it started with the error instead.
+	Operation bool
+	// Times is the number of calls failing, 0 meaning every call.
+	Times int
+	// Err is the error returned, which defaults to an internal server error.
+	Err error
+}
+
+func (ff *FakeFailure) matches(method, kind, project, zone string) bool {
+	match := func(want, got string) bool {
+		return want == "" || want == got
+	}
+	return match(ff.Method, method) && match(ff.Kind, kind) && match(ff.Project, project) && match(ff.Zone, zone)
+}
+
+// FakeInstance is a cluster, a vm or another resource held by the fake GCP APIs.
+type FakeInstance struct {
+	Kind    string
+	Project string
+	Zone    string
+	Name    string
+	// Status is either PROVISIONING, RUNNING or STOPPING.
+	Status string
+	// Version is the master version of a cluster.
+	Version string
+	// Endpoint is the ip address of the master of a cluster.
+	Endpoint string
+	Labels   map[string]string
+}
+
+func (i FakeInstance) key() string {
+	return fmt.Sprintf("%s/%s/%s/%s", i.Kind, i.Project, i.Zone, i.Name)
+}
+
+// fakeOperation is a long running operation, which is pending for the first half of its duration, running for the
+// second half, and then either done or aborting.
+type fakeOperation struct {
+	start time.Time
+	err   error
+	// complete is applied to the instances once the operation is over.
+	complete func(err error)
+}
+
+// FakeGCP is an in-memory implementation of the GKE and GCE APIs used to construct resources, such that resources
+// can be constructed without GCP. The configuration fields must be set before the fake is used. It is safe for
+// concurrent use.
+type FakeGCP struct {
+	// Zones are the zones of every project, returned in order.
+	Zones []string
+	// DefaultVersion and ValidVersions make up the GKE server config.
+	DefaultVersion string
+	ValidVersions  []string
+	// Latency is the time taken by every API call.
+	Latency time.Duration
+	// OperationDuration is the time taken by the operations creating and deleting instances.
+	OperationDuration time.Duration
+	// PollInterval is the time to wait between two checks of an operation.
+	PollInterval time.Duration
+
+	mu         sync.Mutex
+	now        func() time.Time
+	count      int
+	failures   []*FakeFailure
+	instances  map[string]*FakeInstance
+	operations map[string]*fakeOperation
+}
+
+// NewFakeGCP creates a fake GCP with a few zones and GKE versions, in which operations complete immediately.
+func NewFakeGCP() *FakeGCP {
+	return &FakeGCP{
+		Zones:          defaultFakeZones,
+		DefaultVersion: defaultFakeVersions[len(defaultFakeVersions)-1],
+		ValidVersions:  defaultFakeVersions,
+		PollInterval:   defaultFakePollInterval,
+		now:            time.Now,
+		instances:      map[string]*FakeInstance{},
+		operations:     map[string]*fakeOperation{},
+	}
+}
+
+// NewFakeClient creates a client constructing resources in the given fake instead of GCP.
+func NewFakeClient(f *FakeGCP) *Client {
+	return &Client{
+		gke:              &fakeContainerEngine{f},
+		gce:              &fakeComputeEngine{f},
+		kinds:            newFakeResourceKinds(f),
+		tokenSource:      oauth2.StaticTokenSource(&oauth2.Token{AccessToken: FakeToken}),
+		operationTimeout: defaultOperationTimeout,
+		retryDelay:       f.PollInterval,
+	}
+}
+
+// Fail injects failures in the calls to the fake. The first matching failure is used.
+func (f *FakeGCP) Fail(failures ...FakeFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range failures {
+		ff := failures[i]
+		if ff.Err == nil {
+			ff.Err = &googleapi.Error{Code: http.StatusInternalServerError, Message: "injected failure"}
+		}
+		f.failures = append(f.failures, &ff)
+	}
+}
+
+// Instances lists the instances of the fake, sorted by kind, project, zone and name.
+func (f *FakeGCP) Instances() []FakeInstance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.progress()
+	var instances []FakeInstance
+	for _, i := range f.instances {
+		instances = append(instances, *i)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].key() < instances[j].key() })
+	return instances
+}
+
+// call simulates an API call, returning either the error of the call or the error aborting the operation it starts.
+func (f *FakeGCP) call(ctx context.Context, method, kind, project, zone string) (opErr error, err error) {
+	if f.Latency > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(f.Latency):
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, ff := range f.failures {
+		if !ff.matches(method, kind, project, zone) {
+			continue
+		}
+		if ff.Times > 0 {
+			ff.Times--
+			if ff.Times == 0 {
+				f.failures = append(f.failures[:i], f.failures[i+1:]...)
+			}
+		}
+		if ff.Operation {
+			return ff.Err, nil
+		}
+		return nil, ff.Err
+	}
+
+	// Other kinds have locations of their own, such as the multi-regions of buckets.
+	if zone != "" && (kind == FakeCluster || kind == FakeVM) && !f.hasLocation(zone) {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("location %s not found", zone)}
+	}
+	return nil, nil
+}
+
+// hasLocation checks if a location is one of the zones of the fake, or one of their regions.
+func (f *FakeGCP) hasLocation(location string) bool {
+	for _, z := range f.Zones {
+		if z == location || regionOf(z) == location {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeGCP) listZones(ctx context.Context, project string) ([]string, error) {
+	if _, err := f.call(ctx, FakeListZones, "", project, ""); err != nil {
+		return nil, err
+	}
+	if len(f.Zones) == 0 {
+		return nil, fmt.Errorf("no zone found")
+	}
+	return append([]string{}, f.Zones...), nil
+}
+
+func (f *FakeGCP) serverConfig(ctx context.Context, project, zone string) (string, []string, error) {
+	if _, err := f.call(ctx, FakeServerConfig, FakeCluster, project, zone); err != nil {
+		return "", nil, err
+	}
+	return f.DefaultVersion, f.ValidVersions, nil
+}
+
+// startOperation starts an operation completed after OperationDuration. Must be called with the lock held.
+func (f *FakeGCP) startOperation(err error, complete func(error)) string {
+	f.count++
+	name := fmt.Sprintf("operation-%d", f.count)
+	f.operations[name] = &fakeOperation{start: f.now(), err: err, complete: complete}
+	return name
+}
+
+// progress completes the operations that are over. Must be called with the lock held.
+func (f *FakeGCP) progress() {
+	for _, op := range f.operations {
+		if op.complete != nil && f.now().Sub(op.start) >= f.OperationDuration {
+			op.complete(op.err)
+			op.complete = nil
+		}
+	}
+}
+
+// insert starts the creation of an instance, returning the name of the operation.
+func (f *FakeGCP) insert(ctx context.Context, instance FakeInstance) (string, error) {
+	opErr, err := f.call(ctx, FakeCreate, instance.Kind, instance.Project, instance.Zone)
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := instance.key()
+	if _, ok := f.instances[key]; ok {
+		return "", &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("%s %s already exists", instance.Kind, instance.Name)}
+	}
+	instance.Status = instanceProvisioning
+	if instance.Kind == FakeCluster {
+		instance.Endpoint = fmt.Sprintf("10.0.%d.%d", f.count/256, f.count%256)
+	}
+	f.instances[key] = &instance
+	return f.startOperation(opErr, func(err error) {
+		// The instance may be deleted while it is provisioned.
+		if f.instances[key] != &instance || instance.Status != instanceProvisioning {
+			return
+		}
+		if err != nil {
+			delete(f.instances, key)
+			return
+		}
+		instance.Status = instanceRunning
+	}), nil
+}
+
+// remove starts the deletion of an instance, returning the name of the operation.
+func (f *FakeGCP) remove(ctx context.Context, kind, project string, info InstanceInfo) (string, error) {
+	opErr, err := f.call(ctx, FakeDelete, kind, project, info.Zone)
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.progress()
+	key := FakeInstance{Kind: kind, Project: project, Zone: info.Zone, Name: info.Name}.key()
+	instance, ok := f.instances[key]
+	if !ok || instance.Status == instanceStopping {
+		return "", &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("%s %s not found", kind, info.Name)}
+	}
+	// Like GKE, a cluster can not be deleted while it is being created.
+	if kind == FakeCluster && instance.Status == instanceProvisioning {
+		return "", &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("cluster %s is being created", info.Name)}
+	}
+	status := instance.Status
+	instance.Status = instanceStopping
+	return f.startOperation(opErr, func(err error) {
+		if f.instances[key] != instance {
+			return
+		}
+		if err != nil {
+			instance.Status = status
+			return
+		}
+		delete(f.instances, key)
+	}), nil
+}
+
+// cluster describes a cluster like the GKE API.
+func (f *FakeGCP) cluster(ctx context.Context, project string, info InstanceInfo) (*container.Cluster, error) {
+	if _, err := f.call(ctx, FakeGet, FakeCluster, project, info.Zone); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.progress()
+	instance, ok := f.instances[FakeInstance{Kind: FakeCluster, Project: project, Zone: info.Zone, Name: info.Name}.key()]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("cluster %s not found", info.Name)}
+	}
+	return &container.Cluster{
+		Name:                 instance.Name,
+		Location:             instance.Zone,
+		Status:               instance.Status,
+		CurrentMasterVersion: instance.Version,
+		Endpoint:             instance.Endpoint,
+		MasterAuth: &container.MasterAuth{
+			ClusterCaCertificate: base64.StdEncoding.EncodeToString([]byte("ca of " + instance.Name)),
+		},
+	}, nil
+}
+
+// describe describes an instance of another kind than clusters and vms.
+func (f *FakeGCP) describe(ctx context.Context, kind, project string, info InstanceInfo) (string, error) {
+	if _, err := f.call(ctx, FakeGet, kind, project, info.Zone); err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.progress()
+	if _, ok := f.instances[FakeInstance{Kind: kind, Project: project, Zone: info.Zone, Name: info.Name}.key()]; !ok {
+		return "", &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("%s %s not found", kind, info.Name)}
+	}
+	return fmt.Sprintf("fake://%s/%s/%s", project, kind, info.Name), nil
+}
+
+// list lists the instances of a kind in a project that have the given label.
+func (f *FakeGCP) list(ctx context.Context, kind, project, label string) ([]labeledInstance, error) {
+	if _, err := f.call(ctx, FakeList, kind, project, ""); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.progress()
+	var instances []labeledInstance
+	for _, i := range f.instances {
+		if _, ok := i.Labels[label]; !ok || i.Kind != kind || i.Project != project {
+			continue
+		}
+		instances = append(instances, labeledInstance{InstanceInfo: InstanceInfo{Name: i.Name, Zone: i.Zone}, Labels: i.Labels})
+	}
+	return instances, nil
+}
+
+// operation returns the status of an operation, and the error message of aborted operations.
+func (f *FakeGCP) operation(name string) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	op, ok := f.operations[name]
+	if !ok {
+		return "", "", &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("operation %s not found", name)}
+	}
+	f.progress()
+	elapsed := f.now().Sub(op.start)
+	switch {
+	case elapsed < f.OperationDuration/2:
+		return operationPending, "", nil
+	case elapsed < f.OperationDuration:
+		return operationRunning, "", nil
+	case op.err != nil:
+		return operationAborting, op.err.Error(), nil
+	default:
+		return operationDone, "", nil
+	}
+}
+
+func (f *FakeGCP) waitForOperation(ctx context.Context, name string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(f.PollInterval):
+			status, message, err := f.operation(name)
+			if err != nil {
+				return err
+			}
+			switch status {
+			case operationDone:
+				return nil
+			case operationAborting:
+				return errors.New(message)
+			default:
+				logrus.Debugf("operation %s status is %s", name, status)
+			}
+		}
+	}
+}
+
+// fakeContainerEngine creates clusters in a fake GCP.
+type fakeContainerEngine struct {
+	f *FakeGCP
+}
+
+func (cc *fakeContainerEngine) create(ctx context.Context, project string, config clusterConfig) (*InstanceInfo, error) {
+	defaultVersion, validVersions, err := cc.f.serverConfig(ctx, project, config.Zone)
+	if err != nil {
+		return nil, err
+	}
+	// Like GKE, using the default version when the version does not match any valid version.
+	version := findVersionMatch(config.Version, validVersions)
+	if config.Version == "" || version == "" {
+		version = defaultVersion
+	}
+	name := generateName("gke")
+	op, err := cc.f.insert(ctx, FakeInstance{Kind: FakeCluster, Project: project, Zone: config.Zone, Name: name, Version: version, Labels: config.Labels})
+	if err != nil {
+		return nil, err
+	}
+	info := &InstanceInfo{Name: name, Zone: config.Zone}
+	if err := cc.f.waitForOperation(ctx, op); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+func (cc *fakeContainerEngine) get(ctx context.Context, project string, info InstanceInfo) (*container.Cluster, error) {
+	return cc.f.cluster(ctx, project, info)
+}
+
+func (cc *fakeContainerEngine) list(ctx context.Context, project string) ([]labeledInstance, error) {
+	return cc.f.list(ctx, FakeCluster, project, resourceLabel)
+}
+
+func (cc *fakeContainerEngine) delete(ctx context.Context, project string, info InstanceInfo) error {
+	op, err := cc.f.remove(ctx, FakeCluster, project, info)
+	if err != nil {
+		return err
+	}
+	return cc.f.waitForOperation(ctx, op)
+}
+
+// fakeComputeEngine creates vms in a fake GCP.
+type fakeComputeEngine struct {
+	f *FakeGCP
+}
+
+func (cc *fakeComputeEngine) listZones(project string) ([]string, error) {
+	return cc.f.listZones(context.Background(), project)
+}
+
+func (cc *fakeComputeEngine) create(ctx context.Context, project string, config virtualMachineConfig) (*InstanceInfo, error) {
+	name := generateName("gce")
+	op, err := cc.f.insert(ctx, FakeInstance{Kind: FakeVM, Project: project, Zone: config.Zone, Name: name, Labels: config.Labels})
+	if err != nil {
+		return nil, err
+	}
+	info := &InstanceInfo{Name: name, Zone: config.Zone}
+	if err := cc.f.waitForOperation(ctx, op); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+func (cc *fakeComputeEngine) list(ctx context.Context, project string) ([]labeledInstance, error) {
+	return cc.f.list(ctx, FakeVM, project, resourceLabel)
+}
+
+func (cc *fakeComputeEngine) delete(ctx context.Context, project string, info InstanceInfo) error {
+	op, err := cc.f.remove(ctx, FakeVM, project, info)
+	if err != nil {
+		return err
+	}
+	return cc.f.waitForOperation(ctx, op)
+}
+
+// fakeResourceKind creates resources of another kind than clusters and vms in a fake GCP, validating their configs
+// like the real kind.
+type fakeResourceKind struct {
+	resourceKind
+	f    *FakeGCP
+	kind string
+}
+
+func newFakeResourceKinds(f *FakeGCP) map[string]resourceKind {
+	kinds := map[string]resourceKind{}
+	for name, kind := range newResourceKinds(nil, nil, nil) {
+		kinds[name] = &fakeResourceKind{resourceKind: kind, f: f, kind: name}
+	}
+	return kinds
+}
+
+func (k *fakeResourceKind) create(ctx context.Context, project string, config kindConfig) (*InstanceInfo, error) {
+	name := generateName(k.kind)
+	op, err := k.f.insert(ctx, FakeInstance{Kind: k.kind, Project: project, Zone: config.Location, Name: name, Labels: config.Labels})
+	if err != nil {
+		return nil, err
+	}
+	info := &InstanceInfo{Name: name, Zone: config.Location}
+	if err := k.f.waitForOperation(ctx, op); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+func (k *fakeResourceKind) delete(ctx context.Context, project string, info InstanceInfo) error {
+	op, err := k.f.remove(ctx, k.kind, project, info)
+	if err != nil {
+		return err
+	}
+	return k.f.waitForOperation(ctx, op)
+}
+
+func (k *fakeResourceKind) describe(ctx context.Context, project string, info InstanceInfo) (string, error) {
+	return k.f.describe(ctx, k.kind, project, info)
+}
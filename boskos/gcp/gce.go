@@ -18,6 +18,9 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"net"
+	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -27,9 +30,14 @@ import (
 
 const (
 	// ResourceConfigType defines the GCP config type
-	persistent   = "PERSISTENT"
-	oneToOneNAT  = "ONE_TO_ONE_NAT"
-	zoneUSPrefix = "us-"
+	persistent  = "PERSISTENT"
+	oneToOneNAT = "ONE_TO_ONE_NAT"
+	// Defined in https://cloud.google.com/compute/docs/storing-retrieving-metadata
+	startupScriptKey         = "startup-script"
+	enableGuestAttributesKey = "enable-guest-attributes"
+	defaultServiceAccount    = "default"
+	sshPort                  = "22"
+	sshDialTimeout           = 5 * time.Second
 )
 
 type virtualMachineConfig struct {
@@ -38,6 +46,64 @@ type virtualMachineConfig struct {
 	Zone        string   `json:"zone,omitempty"`
 	Tags        []string `json:"tags,omitempty"`
 	Scopes      []string `json:"scopes,omitempty"`
+	// Network and Subnetwork are names in the project, or paths like projects/p/regions/r/subnetworks/s.
+	Network      string `json:"network,omitempty"`
+	Subnetwork   string `json:"subnetwork,omitempty"`
+	NoExternalIP bool   `json:"noexternalip"`
+	// DiskSizeGb and DiskType configure the boot disk.
+	DiskSizeGb     int64              `json:"disksizegb,omitempty"`
+	DiskType       string             `json:"disktype,omitempty"`
+	Disks          []diskConfig       `json:"disks,omitempty"`
+	StartupScript  string             `json:"startupscript,omitempty"`
+	Metadata       map[string]string  `json:"metadata,omitempty"`
+	Labels         map[string]string  `json:"labels,omitempty"`
+	ServiceAccount string             `json:"serviceaccount,omitempty"`
+	Readiness      *vmReadinessConfig `json:"readiness,omitempty"`
+}
+
+// diskConfig is a persistent disk attached to a vm in addition to its boot disk, and deleted with it.
+type diskConfig struct {
+	SizeGb      int64  `json:"sizegb,omitempty"`
+	Type        string `json:"type,omitempty"`
+	SourceImage string `json:"sourceimage,omitempty"`
+}
+
+// vmReadinessConfig configures the checks of a vm once it is created. Without checks, vms are ready once created.
+type vmReadinessConfig struct {
+	// Timeout bounds the time waited for the vm to be ready, 5 minutes by default.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// SSH waits for the ssh port of the vm to accept connections, on its external ip or on its internal ip if it has
+	// none.
+	SSH bool `json:"ssh"`
+	// GuestAttribute waits for a guest attribute, named like namespace/key, to be set by the vm.
+	GuestAttribute string `json:"guestattribute,omitempty"`
+}
+
+func (r *vmReadinessConfig) timeout() time.Duration {
+	if r == nil || r.Timeout == 0 {
+		return defaultReadyTimeout
+	}
+	return r.Timeout
+}
+
+func (c virtualMachineConfig) validate() error {
+	if c.StartupScript != "" && c.Metadata[startupScriptKey] != "" {
+		return fmt.Errorf("startup script is set both as startupscript and in metadata")
+	}
+	for i, d := range c.Disks {
+		if d.SizeGb <= 0 && d.SourceImage == "" {
+			return fmt.Errorf("disk %d needs a size or a source image", i)
+		}
+	}
+	if r := c.Readiness; r != nil {
+		if r.Timeout < 0 {
+			return fmt.Errorf("invalid readiness timeout %v", r.Timeout)
+		}
+		if r.GuestAttribute != "" && strings.Count(r.GuestAttribute, "/") != 1 {
+			return fmt.Errorf("guest attribute %s is not named like namespace/key", r.GuestAttribute)
+		}
+	}
+	return nil
 }
 
 type computeEngine struct {
@@ -51,9 +117,7 @@ func (cc *computeEngine) listZones(project string) ([]string, error) {
 	}
 	var zones []string
 	for _, z := range zoneList.Items {
-		if strings.HasPrefix(z.Name, zoneUSPrefix) {
-			zones = append(zones, z.Name)
-		}
+		zones = append(zones, z.Name)
 	}
 	if len(zones) == 0 {
 		return nil, fmt.Errorf("no zone found")
@@ -89,10 +153,48 @@ func (cc *computeEngine) waitForOperation(ctx context.Context, op *compute.Opera
 	}
 }
 
+// resourcePath returns the path of a resource given by name, or the resource itself if it is a path already.
+func resourcePath(name, format string, args ...interface{}) string {
+	if name == "" || strings.Contains(name, "/") {
+		return name
+	}
+	return fmt.Sprintf(format, append(args, name)...)
+}
+
+func newMetadata(config virtualMachineConfig) *compute.Metadata {
+	values := map[string]string{}
+	for k, v := range config.Metadata {
+		values[k] = v
+	}
+	if config.StartupScript != "" {
+		values[startupScriptKey] = config.StartupScript
+	}
+	if config.Readiness != nil && config.Readiness.GuestAttribute != "" {
+		values[enableGuestAttributesKey] = "TRUE"
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	metadata := &compute.Metadata{}
+	for _, k := range keys {
+		v := values[k]
+		metadata.Items = append(metadata.Items, &compute.MetadataItems{Key: k, Value: &v})
+	}
+	return metadata
+}
+
 func newComputeInstance(config virtualMachineConfig, project, name string) *compute.Instance {
 	// Inconsistency between compute and container APIs
 	machineType := fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", project, config.Zone, config.MachineType)
 	zone := fmt.Sprintf("projects/%s/zones/%s", project, config.Zone)
+	diskType := func(t string) string {
+		return resourcePath(t, "projects/%s/zones/%s/diskTypes/%s", project, config.Zone)
+	}
 	instance := &compute.Instance{
 		Name:         name,
 		Zone:         zone,
@@ -106,25 +208,50 @@ func newComputeInstance(config virtualMachineConfig, project, name string) *comp
 				InitializeParams: &compute.AttachedDiskInitializeParams{
 					DiskName:    name,
 					SourceImage: config.SourceImage,
+					DiskSizeGb:  config.DiskSizeGb,
+					DiskType:    diskType(config.DiskType),
 				},
 			},
 		},
 		NetworkInterfaces: []*compute.NetworkInterface{
 			{
-				AccessConfigs: []*compute.AccessConfig{
-					{
-						Name: "External NAT",
-						Type: oneToOneNAT,
-					},
-				},
+				Network:    resourcePath(config.Network, "projects/%s/global/networks/%s", project),
+				Subnetwork: resourcePath(config.Subnetwork, "projects/%s/regions/%s/subnetworks/%s", project, regionOf(config.Zone)),
 			},
 		},
 		ServiceAccounts: []*compute.ServiceAccount{
 			{
-				Email:  "default",
+				Email:  config.ServiceAccount,
 				Scopes: config.Scopes,
 			},
 		},
+		Metadata: newMetadata(config),
+		Labels:   config.Labels,
+	}
+	for i, d := range config.Disks {
+		diskName := fmt.Sprintf("%s-%d", name, i+1)
+		instance.Disks = append(instance.Disks, &compute.AttachedDisk{
+			AutoDelete: true,
+			DeviceName: diskName,
+			Type:       persistent,
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				DiskName:    diskName,
+				SourceImage: d.SourceImage,
+				DiskSizeGb:  d.SizeGb,
+				DiskType:    diskType(d.Type),
+			},
+		})
+	}
+	if !config.NoExternalIP {
+		instance.NetworkInterfaces[0].AccessConfigs = []*compute.AccessConfig{
+			{
+				Name: "External NAT",
+				Type: oneToOneNAT,
+			},
+		}
+	}
+	if instance.ServiceAccounts[0].Email == "" {
+		instance.ServiceAccounts[0].Email = defaultServiceAccount
 	}
 	if config.Tags != nil {
 		instance.Tags = &compute.Tags{Items: config.Tags}
@@ -132,6 +259,104 @@ func newComputeInstance(config virtualMachineConfig, project, name string) *comp
 	return instance
 }
 
+// instanceAddress returns the external ip of a vm, or its internal ip if it has none.
+func instanceAddress(instance *compute.Instance) string {
+	for _, ni := range instance.NetworkInterfaces {
+		for _, ac := range ni.AccessConfigs {
+			if ac.NatIP != "" {
+				return ac.NatIP
+			}
+		}
+	}
+	for _, ni := range instance.NetworkInterfaces {
+		if ni.NetworkIP != "" {
+			return ni.NetworkIP
+		}
+	}
+	return ""
+}
+
+// checkReachable checks that an address accepts tcp connections.
+func checkReachable(address string) error {
+	conn, err := net.DialTimeout("tcp", address, sshDialTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkReady checks a vm once, as configured by its readiness.
+func (cc *computeEngine) checkReady(ctx context.Context, project string, info InstanceInfo, readiness *vmReadinessConfig) error {
+	if readiness.SSH {
+		instance, err := cc.service.Instances.Get(project, info.Zone, info.Name).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		address := instanceAddress(instance)
+		if address == "" {
+			return fmt.Errorf("vm %s has no ip", info.Name)
+		}
+		if err := checkReachable(net.JoinHostPort(address, sshPort)); err != nil {
+			return fmt.Errorf("ssh of vm %s is not reachable: %v", info.Name, err)
+		}
+	}
+	if readiness.GuestAttribute != "" {
+		attributes, err := cc.service.Instances.GetGuestAttributes(project, info.Zone, info.Name).QueryPath(readiness.GuestAttribute).Context(ctx).Do()
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if err != nil || attributes.QueryValue == nil || len(attributes.QueryValue.Items) == 0 {
+			return fmt.Errorf("guest attribute %s of vm %s is not set", readiness.GuestAttribute, info.Name)
+		}
+	}
+	return nil
+}
+
+func (cc *computeEngine) waitForReady(ctx context.Context, project string, info InstanceInfo, readiness *vmReadinessConfig) error {
+	logrus.Infof("Verifying that vm %s in zone %s for project %s is ready", info.Name, info.Zone, project)
+	if err := pollReady(ctx, "vm "+info.Name, defaultSleepTime, func() error {
+		return cc.checkReady(ctx, project, info, readiness)
+	}); err != nil {
+		return err
+	}
+	logrus.Infof("vm %s in zone %s for project %s is ready", info.Name, info.Zone, project)
+	return nil
+}
+
+// list lists the vms of a project in all its zones, except the nodes of clusters.
+func (cc *computeEngine) list(ctx context.Context, project string) ([]labeledInstance, error) {
+	var vms []labeledInstance
+	call := cc.service.Instances.AggregatedList(project).Filter(fmt.Sprintf("labels.%s:*", resourceLabel))
+	err := call.Pages(ctx, func(list *compute.InstanceAggregatedList) error {
+		for _, scoped := range list.Items {
+			for _, i := range scoped.Instances {
+				if _, ok := i.Labels[gkeNodeLabel]; ok {
+					continue
+				}
+				if _, ok := i.Labels[resourceLabel]; !ok {
+					continue
+				}
+				// Zones of instances are urls.
+				vms = append(vms, labeledInstance{InstanceInfo: InstanceInfo{Name: i.Name, Zone: path.Base(i.Zone)}, Labels: i.Labels})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vms, nil
+}
+
+func (cc *computeEngine) delete(ctx context.Context, project string, info InstanceInfo) error {
+	op, err := cc.service.Instances.Delete(project, info.Zone, info.Name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Instance %s being deleted via operation %s, waiting for completion", info.Name, op.Name)
+	return cc.waitForOperation(ctx, op, project, info.Zone)
+}
+
 func (cc *computeEngine) create(ctx context.Context, project string, config virtualMachineConfig) (*InstanceInfo, error) {
 	name := generateName("gce")
 	instance := newComputeInstance(config, project, name)
@@ -141,10 +366,21 @@ func (cc *computeEngine) create(ctx context.Context, project string, config virt
 		return nil, err
 	}
 	logrus.Infof("Instance %s being created via operation %s, waiting for completion", instance.Name, op.Name)
+	// From now on the vm may exist, and is returned along with errors such that it can be deleted.
+	info := &InstanceInfo{Name: name, Zone: config.Zone}
 	if err := cc.waitForOperation(ctx, op, project, config.Zone); err != nil {
 		logrus.WithError(err).Errorf("operation %s failed", op.Name)
-		return nil, err
+		return info, err
 	}
 	logrus.Infof("Instance %s created via operation %s", instance.Name, op.Name)
-	return &InstanceInfo{Name: name, Zone: config.Zone}, nil
+	if config.Readiness == nil || (!config.Readiness.SSH && config.Readiness.GuestAttribute == "") {
+		return info, nil
+	}
+	readyCtx, cancel := context.WithTimeout(ctx, config.Readiness.timeout())
+	defer cancel()
+	if err := cc.waitForReady(readyCtx, project, *info, config.Readiness); err != nil {
+		logrus.WithError(err).Errorf("vm %s in zone %s for project %s is not usable", name, config.Zone, project)
+		return info, err
+	}
+	return info, nil
 }
@@ -0,0 +1,245 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+
+	"k8s.io/test-infra/boskos/common"
+)
+
+func TestParseVMConfig(t *testing.T) {
+	expected := resourceConfigs{
+		"type1": {{
+			Vms: []virtualMachineConfig{{
+				MachineType:  "n1-standard-4",
+				SourceImage:  "projects/debian-cloud/global/images/family/debian-10",
+				Network:      "test-network",
+				Subnetwork:   "projects/shared/regions/us-central1/subnetworks/test-subnet",
+				NoExternalIP: true,
+				DiskSizeGb:   50,
+				Disks:        []diskConfig{{SizeGb: 100, Type: "pd-ssd"}},
+				StartupScript: `#!/bin/bash
+curl -X PUT --data ready -H "Metadata-Flavor: Google" \
+  http://metadata.google.internal/computeMetadata/v1/instance/guest-attributes/boskos/ready
+`,
+				Metadata:       map[string]string{"role": "test"},
+				Labels:         map[string]string{"team": "perf"},
+				ServiceAccount: "vm@project.iam.gserviceaccount.com",
+				Readiness:      &vmReadinessConfig{Timeout: 3 * time.Minute, GuestAttribute: "boskos/ready"},
+			}},
+		}},
+	}
+
+	conf, err := common.ParseConfig("test-configs.yaml")
+	if err != nil {
+		t.Fatal("could not parse config")
+	}
+	config, err := ConfigConverter(conf.Resources[3].Config.Content)
+	if err != nil {
+		t.Fatalf("cannot parse object: %v", err)
+	}
+	if !reflect.DeepEqual(expected, *config.(*resourceConfigs)) {
+		t.Errorf("expected %v, got %v", expected, *config.(*resourceConfigs))
+	}
+}
+
+func TestNewComputeInstance(t *testing.T) {
+	instance := newComputeInstance(virtualMachineConfig{Zone: "us-central1-a", MachineType: "n1-standard-1"}, "p", "vm")
+	if ni := instance.NetworkInterfaces[0]; ni.Network != "" || ni.Subnetwork != "" || len(ni.AccessConfigs) != 1 {
+		t.Errorf("expected default network with an external ip, got %+v", ni)
+	}
+	if sa := instance.ServiceAccounts[0].Email; sa != defaultServiceAccount {
+		t.Errorf("expected service account %s, got %s", defaultServiceAccount, sa)
+	}
+	if len(instance.Disks) != 1 || instance.Metadata != nil || instance.Labels != nil {
+		t.Errorf("expected a boot disk only, and no metadata nor labels, got %+v", instance)
+	}
+
+	config := virtualMachineConfig{
+		Zone:           "us-central1-a",
+		MachineType:    "n1-standard-1",
+		Network:        "net",
+		Subnetwork:     "subnet",
+		NoExternalIP:   true,
+		DiskType:       "pd-ssd",
+		Disks:          []diskConfig{{SizeGb: 10}, {Type: "pd-ssd", SourceImage: "image"}},
+		StartupScript:  "echo",
+		Metadata:       map[string]string{"b": "2", "a": "1"},
+		Labels:         map[string]string{"team": "perf"},
+		ServiceAccount: "vm@p.iam.gserviceaccount.com",
+		Readiness:      &vmReadinessConfig{GuestAttribute: "boskos/ready"},
+	}
+	instance = newComputeInstance(config, "p", "vm")
+	ni := instance.NetworkInterfaces[0]
+	if ni.Network != "projects/p/global/networks/net" || ni.Subnetwork != "projects/p/regions/us-central1/subnetworks/subnet" {
+		t.Errorf("unexpected network %s and subnetwork %s", ni.Network, ni.Subnetwork)
+	}
+	if len(ni.AccessConfigs) != 0 {
+		t.Errorf("expected no external ip, got %+v", ni.AccessConfigs)
+	}
+	var disks []compute.AttachedDiskInitializeParams
+	for _, d := range instance.Disks {
+		disks = append(disks, *d.InitializeParams)
+	}
+	expectedDisks := []compute.AttachedDiskInitializeParams{
+		{DiskName: "vm", DiskType: "projects/p/zones/us-central1-a/diskTypes/pd-ssd"},
+		{DiskName: "vm-1", DiskSizeGb: 10},
+		{DiskName: "vm-2", SourceImage: "image", DiskType: "projects/p/zones/us-central1-a/diskTypes/pd-ssd"},
+	}
+	if !reflect.DeepEqual(disks, expectedDisks) {
+		t.Errorf("expected disks %+v, got %+v", expectedDisks, disks)
+	}
+	metadata := map[string]string{}
+	var keys []string
+	for _, item := range instance.Metadata.Items {
+		keys = append(keys, item.Key)
+		metadata[item.Key] = *item.Value
+	}
+	if expected := []string{"a", "b", enableGuestAttributesKey, startupScriptKey}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected metadata keys %v, got %v", expected, keys)
+	}
+	if metadata[startupScriptKey] != "echo" || metadata[enableGuestAttributesKey] != "TRUE" {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+	if !reflect.DeepEqual(instance.Labels, config.Labels) || instance.ServiceAccounts[0].Email != config.ServiceAccount {
+		t.Errorf("unexpected labels %v or service account %s", instance.Labels, instance.ServiceAccounts[0].Email)
+	}
+}
+
+func TestVirtualMachineConfigValidate(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		config virtualMachineConfig
+		err    string
+	}{
+		{
+			name: "valid",
+			config: virtualMachineConfig{
+				StartupScript: "echo",
+				Disks:         []diskConfig{{SizeGb: 10}, {SourceImage: "image"}},
+				Readiness:     &vmReadinessConfig{SSH: true, GuestAttribute: "boskos/ready"},
+			},
+		},
+		{
+			name:   "startup script set twice",
+			config: virtualMachineConfig{StartupScript: "echo", Metadata: map[string]string{startupScriptKey: "echo"}},
+			err:    "startup script is set both as startupscript and in metadata",
+		},
+		{
+			name:   "empty disk",
+			config: virtualMachineConfig{Disks: []diskConfig{{SizeGb: 10}, {Type: "pd-ssd"}}},
+			err:    "disk 1 needs a size or a source image",
+		},
+		{
+			name:   "negative readiness timeout",
+			config: virtualMachineConfig{Readiness: &vmReadinessConfig{Timeout: -time.Second}},
+			err:    "invalid readiness timeout -1s",
+		},
+		{
+			name:   "guest attribute without namespace",
+			config: virtualMachineConfig{Readiness: &vmReadinessConfig{GuestAttribute: "ready"}},
+			err:    "guest attribute ready is not named like namespace/key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var errString string
+			if err := tc.config.validate(); err != nil {
+				errString = err.Error()
+			}
+			if errString != tc.err {
+				t.Errorf("expected error %q, got %q", tc.err, errString)
+			}
+		})
+	}
+}
+
+func TestCheckReachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	if err := checkReachable(address); err != nil {
+		t.Errorf("expected %s to be reachable, got %v", address, err)
+	}
+	l.Close()
+	if err := checkReachable(address); err == nil {
+		t.Errorf("expected %s not to be reachable once closed", address)
+	}
+}
+
+func TestComputeEngineCheckReady(t *testing.T) {
+	var ready bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/p/zones/us-central1-a/instances/vm"):
+			json.NewEncoder(w).Encode(&compute.Instance{
+				Name:              "vm",
+				NetworkInterfaces: []*compute.NetworkInterface{{}},
+			})
+		case strings.HasSuffix(r.URL.Path, "/p/zones/us-central1-a/instances/vm/getGuestAttributes"):
+			if r.URL.Query().Get("queryPath") != "boskos/ready" || !ready {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+				return
+			}
+			json.NewEncoder(w).Encode(&compute.GuestAttributes{
+				QueryValue: &compute.GuestAttributesValue{
+					Items: []*compute.GuestAttributesEntry{{Namespace: "boskos", Key: "ready", Value: "ready"}},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	service, err := compute.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := &computeEngine{service}
+	info := InstanceInfo{Name: "vm", Zone: "us-central1-a"}
+
+	check := func(readiness vmReadinessConfig, expected string) {
+		t.Helper()
+		var errString string
+		if err := cc.checkReady(context.Background(), "p", info, &readiness); err != nil {
+			errString = err.Error()
+		}
+		if errString != expected {
+			t.Errorf("expected error %q, got %q", expected, errString)
+		}
+	}
+	check(vmReadinessConfig{SSH: true}, "vm vm has no ip")
+	check(vmReadinessConfig{GuestAttribute: "boskos/ready"}, "guest attribute boskos/ready of vm vm is not set")
+	ready = true
+	check(vmReadinessConfig{GuestAttribute: "boskos/ready"}, "")
+}
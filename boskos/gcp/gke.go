@@ -17,23 +17,30 @@ package gcp
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
 	container "google.golang.org/api/container/v1beta1"
-
-	"istio.io/test-infra/toolbox/util"
+	"k8s.io/client-go/kubernetes"
 )
 
 const (
 	defaultSleepTime = 10 * time.Second
-	readyTimeout     = time.Minute
 	// Defined in https://godoc.org/google.golang.org/api/container/v1#Operation
 	operationDone     = "DONE"
 	operationAborting = "ABORTING"
+	// Defined in https://godoc.org/google.golang.org/api/container/v1beta1#ReleaseChannel
+	releaseChannelRapid   = "RAPID"
+	releaseChannelRegular = "REGULAR"
+	releaseChannelStable  = "STABLE"
+	// Defined in https://godoc.org/google.golang.org/api/container/v1beta1#NodeTaint
+	taintNoSchedule       = "NO_SCHEDULE"
+	taintPreferNoSchedule = "PREFER_NO_SCHEDULE"
+	taintNoExecute        = "NO_EXECUTE"
+	// defaultNodePool is the name of the node pool of a cluster without node pools.
+	defaultNodePool = "default-pool"
 )
 
 type clusterConfig struct {
@@ -41,15 +48,112 @@ type clusterConfig struct {
 	MachineType             string                   `json:"machinetype,omitempty"`
 	Version                 string                   `json:"version,omitempty"`
 	Zone                    string                   `json:"zone,omitempty"`
+	Regional                bool                     `json:"regional"`
 	NumNodes                int64                    `json:"numnodes,omitempty"`
+	DiskSizeGb              int64                    `json:"disksizegb,omitempty"`
+	DiskType                string                   `json:"disktype,omitempty"`
+	Preemptible             bool                     `json:"preemptible"`
+	Autoscaling             *autoscalingConfig       `json:"autoscaling,omitempty"`
+	NodePools               []nodePoolConfig         `json:"nodepools,omitempty"`
+	ReleaseChannel          string                   `json:"releasechannel,omitempty"`
+	Private                 *privateClusterConfig    `json:"private,omitempty"`
+	EnableIPAlias           bool                     `json:"enableipalias"`
+	ClusterIPv4CIDR         string                   `json:"clusteripv4cidr,omitempty"`
+	ServicesIPv4CIDR        string                   `json:"servicesipv4cidr,omitempty"`
 	NetworkPolicy           *container.NetworkPolicy `json:"networkpolicy,omitempty"`
 	EnableKubernetesAlpha   bool                     `json:"enablekubernetesalpha"`
 	EnableWorkloadIdentity  bool                     `json:"enableworkloadidentity"`
 	EnableClientCertificate bool                     `json:"enableclientcertificate"`
+	Readiness               *readinessConfig         `json:"readiness,omitempty"`
+	Labels                  map[string]string        `json:"labels,omitempty"`
+}
+
+// nodePoolConfig is a node pool of a cluster. The machine type, number of nodes, scopes, disk and autoscaling of the
+// cluster are used for the node pools that do not set them, and all node pools are preemptible in a preemptible
+// cluster.
+type nodePoolConfig struct {
+	Name        string                 `json:"name"`
+	MachineType string                 `json:"machinetype,omitempty"`
+	NumNodes    int64                  `json:"numnodes,omitempty"`
+	Scopes      []string               `json:"scopes,omitempty"`
+	DiskSizeGb  int64                  `json:"disksizegb,omitempty"`
+	DiskType    string                 `json:"disktype,omitempty"`
+	Preemptible bool                   `json:"preemptible"`
+	Autoscaling *autoscalingConfig     `json:"autoscaling,omitempty"`
+	Labels      map[string]string      `json:"labels,omitempty"`
+	Taints      []*container.NodeTaint `json:"taints,omitempty"`
+}
+
+// autoscalingConfig bounds the number of nodes of a node pool.
+type autoscalingConfig struct {
+	MinNodes int64 `json:"minnodes"`
+	MaxNodes int64 `json:"maxnodes"`
+}
+
+// privateClusterConfig makes the nodes of a cluster private, and optionally its master endpoint.
+type privateClusterConfig struct {
+	MasterIPv4CIDR        string `json:"masteripv4cidr,omitempty"`
+	EnablePrivateEndpoint bool   `json:"enableprivateendpoint"`
+}
+
+func (a *autoscalingConfig) validate() error {
+	if a == nil {
+		return nil
+	}
+	if a.MinNodes < 0 || a.MaxNodes < 1 || a.MinNodes > a.MaxNodes {
+		return fmt.Errorf("invalid autoscaling bounds [%d, %d]", a.MinNodes, a.MaxNodes)
+	}
+	return nil
+}
+
+func (c clusterConfig) validate() error {
+	switch strings.ToUpper(c.ReleaseChannel) {
+	case "", releaseChannelRapid, releaseChannelRegular, releaseChannelStable:
+	default:
+		return fmt.Errorf("unknown release channel %s", c.ReleaseChannel)
+	}
+	if !c.EnableIPAlias {
+		if c.Private != nil {
+			return fmt.Errorf("private clusters require ip aliases")
+		}
+		if c.ClusterIPv4CIDR != "" || c.ServicesIPv4CIDR != "" {
+			return fmt.Errorf("cluster and services ip ranges require ip aliases")
+		}
+	}
+	if err := c.Autoscaling.validate(); err != nil {
+		return err
+	}
+	if err := c.Readiness.validate(); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, np := range c.NodePools {
+		if np.Name == "" {
+			return fmt.Errorf("node pools must be named")
+		}
+		if names[np.Name] {
+			return fmt.Errorf("node pool %s is defined more than once", np.Name)
+		}
+		names[np.Name] = true
+		if err := np.Autoscaling.validate(); err != nil {
+			return fmt.Errorf("node pool %s: %v", np.Name, err)
+		}
+		for _, t := range np.Taints {
+			switch {
+			case t == nil || t.Key == "":
+				return fmt.Errorf("node pool %s: taints must have a key", np.Name)
+			case t.Effect != taintNoSchedule && t.Effect != taintPreferNoSchedule && t.Effect != taintNoExecute:
+				return fmt.Errorf("node pool %s: unknown effect %s of taint %s", np.Name, t.Effect, t.Key)
+			}
+		}
+	}
+	return nil
 }
 
 type containerEngine struct {
 	service *container.Service
+	// tokenSource authenticates the readiness checks of the clusters created.
+	tokenSource oauth2.TokenSource
 }
 
 func findVersionMatch(version string, supportedVersion []string) string {
@@ -61,52 +165,40 @@ func findVersionMatch(version string, supportedVersion []string) string {
 	return ""
 }
 
-func checkCluster(kubeconfig string) error {
-	_, err := util.Shell("kubectl --kubeconfig=%s get ns", kubeconfig)
-	return err
-}
-
-func (cc *containerEngine) waitForReady(ctx context.Context, cluster, project, zone string) error {
+func (cc *containerEngine) waitForReady(ctx context.Context, cluster, project, zone string, readiness *readinessConfig) error {
 	logrus.Infof("Verifying that cluster %s in zone %s for project %s is ready", cluster, zone, project)
-	kubeconfigFile, err := ioutil.TempFile("", "kubeconfig")
+	clusterObj, err := cc.get(ctx, project, InstanceInfo{Name: cluster, Zone: zone})
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := os.Remove(kubeconfigFile.Name()); err != nil {
-			logrus.WithError(err).Errorf("failed to delete file %s", kubeconfigFile.Name())
-		}
-
-	}()
-
-	if err := SetKubeConfig(project, zone, cluster, kubeconfigFile.Name()); err != nil {
+	config, err := newRESTConfig(clusterObj, cc.tokenSource)
+	if err != nil {
 		return err
 	}
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(defaultSleepTime):
-			err := checkCluster(kubeconfigFile.Name())
-			if err != nil {
-				logrus.WithError(err).Errorf("cluster %s in zone %s for project %s is not ready", cluster, zone, project)
-
-			} else {
-				logrus.Infof("cluster %s in zone %s for project %s is ready", cluster, zone, project)
-				return nil
-			}
-		}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
 	}
+	if err := waitForClusterReady(ctx, client, expectedNodes(clusterObj), readiness.systemPods(), defaultSleepTime); err != nil {
+		return err
+	}
+	logrus.Infof("cluster %s in zone %s for project %s is ready", cluster, zone, project)
+	return nil
 }
 
-func (cc *containerEngine) waitForOperation(ctx context.Context, op *container.Operation, project, zone string) error {
+// locationName is the name of a zone or a region in the GKE API.
+func locationName(project, location string) string {
+	return fmt.Sprintf("projects/%s/locations/%s", project, location)
+}
+
+func (cc *containerEngine) waitForOperation(ctx context.Context, op *container.Operation, project, location string) error {
+	name := fmt.Sprintf("%s/operations/%s", locationName(project, location), op.Name)
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-time.After(defaultSleepTime):
-			newOp, err := cc.service.Projects.Zones.Operations.Get(project, zone, op.Name).Context(ctx).Do()
+			newOp, err := cc.service.Projects.Locations.Operations.Get(name).Context(ctx).Do()
 			if err != nil {
 				return err
 			}
@@ -122,58 +214,173 @@ func (cc *containerEngine) waitForOperation(ctx context.Context, op *container.O
 	}
 }
 
-func (cc *containerEngine) create(ctx context.Context, project string, config clusterConfig) (*InstanceInfo, error) {
-	var version string
-	name := generateName("gke")
-	serverConfig, err := cc.service.Projects.Zones.GetServerconfig(project, config.Zone).Do()
+func (cc *containerEngine) get(ctx context.Context, project string, info InstanceInfo) (*container.Cluster, error) {
+	name := fmt.Sprintf("%s/clusters/%s", locationName(project, info.Zone), info.Name)
+	return cc.service.Projects.Locations.Clusters.Get(name).Context(ctx).Do()
+}
+
+// list lists the clusters of a project in all its locations.
+func (cc *containerEngine) list(ctx context.Context, project string) ([]labeledInstance, error) {
+	resp, err := cc.service.Projects.Locations.Clusters.List(locationName(project, "-")).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
-	if config.Version == "" {
-		version = serverConfig.DefaultClusterVersion
-	} else {
-		version = findVersionMatch(config.Version, serverConfig.ValidMasterVersions)
+	if len(resp.MissingZones) > 0 {
+		return nil, fmt.Errorf("unable to list clusters in zones %v", resp.MissingZones)
+	}
+	var clusters []labeledInstance
+	for _, c := range resp.Clusters {
+		if _, ok := c.ResourceLabels[resourceLabel]; !ok {
+			continue
+		}
+		clusters = append(clusters, labeledInstance{InstanceInfo: InstanceInfo{Name: c.Name, Zone: c.Location}, Labels: c.ResourceLabels})
+	}
+	return clusters, nil
+}
+
+func (cc *containerEngine) delete(ctx context.Context, project string, info InstanceInfo) error {
+	name := fmt.Sprintf("%s/clusters/%s", locationName(project, info.Zone), info.Name)
+	op, err := cc.service.Projects.Locations.Clusters.Delete(name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Instance %s being deleted via operation %s, waiting for completion", info.Name, op.Name)
+	return cc.waitForOperation(ctx, op, project, info.Zone)
+}
+
+func newNodeConfig(config clusterConfig, np nodePoolConfig) *container.NodeConfig {
+	nc := &container.NodeConfig{
+		MachineType: np.MachineType,
+		OauthScopes: np.Scopes,
+		DiskSizeGb:  np.DiskSizeGb,
+		DiskType:    np.DiskType,
+		Preemptible: np.Preemptible || config.Preemptible,
+		Labels:      np.Labels,
+		Taints:      np.Taints,
 	}
-	clusterRequest := &container.CreateClusterRequest{
-		Cluster: &container.Cluster{
-			Name:                  name,
-			InitialClusterVersion: version,
-			InitialNodeCount:      config.NumNodes,
-			NodeConfig: &container.NodeConfig{
-				MachineType: config.MachineType,
-				OauthScopes: config.Scopes,
-			},
-			NetworkPolicy:         config.NetworkPolicy,
-			EnableKubernetesAlpha: config.EnableKubernetesAlpha,
-		},
+	if nc.MachineType == "" {
+		nc.MachineType = config.MachineType
+	}
+	if nc.OauthScopes == nil {
+		nc.OauthScopes = config.Scopes
+	}
+	if nc.DiskSizeGb == 0 {
+		nc.DiskSizeGb = config.DiskSizeGb
+	}
+	if nc.DiskType == "" {
+		nc.DiskType = config.DiskType
+	}
+	return nc
+}
+
+func newNodePool(config clusterConfig, np nodePoolConfig) *container.NodePool {
+	pool := &container.NodePool{
+		Name:             np.Name,
+		Config:           newNodeConfig(config, np),
+		InitialNodeCount: np.NumNodes,
+	}
+	if pool.InitialNodeCount == 0 {
+		pool.InitialNodeCount = config.NumNodes
+	}
+	autoscaling := np.Autoscaling
+	if autoscaling == nil {
+		autoscaling = config.Autoscaling
+	}
+	if autoscaling != nil {
+		pool.Autoscaling = &container.NodePoolAutoscaling{
+			Enabled:      true,
+			MinNodeCount: autoscaling.MinNodes,
+			MaxNodeCount: autoscaling.MaxNodes,
+		}
+	}
+	return pool
+}
+
+func newClusterRequest(project, name, version string, config clusterConfig) *container.CreateClusterRequest {
+	cluster := &container.Cluster{
+		Name:                  name,
+		InitialClusterVersion: version,
+		NetworkPolicy:         config.NetworkPolicy,
+		EnableKubernetesAlpha: config.EnableKubernetesAlpha,
+		ResourceLabels:        config.Labels,
+	}
+
+	nodePools := config.NodePools
+	if len(nodePools) == 0 && config.Autoscaling != nil {
+		// Autoscaling is set per node pool.
+		nodePools = []nodePoolConfig{{Name: defaultNodePool}}
+	}
+	if len(nodePools) == 0 {
+		cluster.InitialNodeCount = config.NumNodes
+		cluster.NodeConfig = newNodeConfig(config, nodePoolConfig{})
+	}
+	for _, np := range nodePools {
+		cluster.NodePools = append(cluster.NodePools, newNodePool(config, np))
+	}
+
+	if config.ReleaseChannel != "" {
+		cluster.ReleaseChannel = &container.ReleaseChannel{Channel: strings.ToUpper(config.ReleaseChannel)}
+	}
+	if config.EnableIPAlias {
+		cluster.IpAllocationPolicy = &container.IPAllocationPolicy{
+			UseIpAliases:          true,
+			ClusterIpv4CidrBlock:  config.ClusterIPv4CIDR,
+			ServicesIpv4CidrBlock: config.ServicesIPv4CIDR,
+		}
+	}
+	if config.Private != nil {
+		cluster.PrivateClusterConfig = &container.PrivateClusterConfig{
+			EnablePrivateNodes:    true,
+			EnablePrivateEndpoint: config.Private.EnablePrivateEndpoint,
+			MasterIpv4CidrBlock:   config.Private.MasterIPv4CIDR,
+		}
 	}
 	// Since Boskos can pick any project in the pool, we need to make sure the identity namespace ties
 	// to the correct project id.
 	if config.EnableWorkloadIdentity {
-		clusterRequest.Cluster.WorkloadIdentityConfig = &container.WorkloadIdentityConfig{
+		cluster.WorkloadIdentityConfig = &container.WorkloadIdentityConfig{
 			IdentityNamespace: fmt.Sprintf("%s.svc.id.goog", project),
 		}
 	}
 	if config.EnableClientCertificate {
-		clusterRequest.Cluster.MasterAuth.ClientCertificateConfig.IssueClientCertificate = true
+		cluster.MasterAuth = &container.MasterAuth{
+			ClientCertificateConfig: &container.ClientCertificateConfig{IssueClientCertificate: true},
+		}
 	}
+	return &container.CreateClusterRequest{Cluster: cluster}
+}
 
-	op, err := cc.service.Projects.Zones.Clusters.Create(project, config.Zone, clusterRequest).Context(ctx).Do()
+func (cc *containerEngine) create(ctx context.Context, project string, config clusterConfig) (*InstanceInfo, error) {
+	var version string
+	name := generateName("gke")
+	serverConfig, err := cc.service.Projects.Locations.GetServerConfig(locationName(project, config.Zone)).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if config.Version == "" {
+		version = serverConfig.DefaultClusterVersion
+	} else {
+		version = findVersionMatch(config.Version, serverConfig.ValidMasterVersions)
+	}
+	clusterRequest := newClusterRequest(project, name, version, config)
+
+	op, err := cc.service.Projects.Locations.Clusters.Create(locationName(project, config.Zone), clusterRequest).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
 	logrus.Infof("Instance %s being created via operation %s, waiting for completion", clusterRequest.Cluster.Name, op.Name)
+	// From now on the cluster may exist, and is returned along with errors such that it can be deleted.
+	info := &InstanceInfo{Name: name, Zone: config.Zone}
 	if err := cc.waitForOperation(ctx, op, project, config.Zone); err != nil {
 		logrus.WithError(err).Errorf("operation %s failed", op.Name)
-		return nil, err
+		return info, err
 	}
 	logrus.Infof("Instance %s created via operation %s", clusterRequest.Cluster.Name, op.Name)
-	info := &InstanceInfo{Name: name, Zone: config.Zone}
-	readyCtx, cancel := context.WithTimeout(ctx, readyTimeout)
+	readyCtx, cancel := context.WithTimeout(ctx, config.Readiness.timeout())
 	defer cancel()
-	if err := cc.waitForReady(readyCtx, name, project, config.Zone); err != nil {
+	if err := cc.waitForReady(readyCtx, name, project, config.Zone, config.Readiness); err != nil {
 		logrus.WithError(err).Errorf("cluster %s in zone %s for project %s is not usable", name, config.Zone, project)
-		return nil, err
+		return info, err
 	}
 	return info, nil
 }
@@ -0,0 +1,309 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	container "google.golang.org/api/container/v1beta1"
+
+	"k8s.io/test-infra/boskos/common"
+)
+
+func TestParseNodePoolsConfig(t *testing.T) {
+	taint := &container.NodeTaint{Key: "dedicated", Value: "perf", Effect: "NO_SCHEDULE"}
+	expected := resourceConfigs{
+		"type1": {{
+			Clusters: []clusterConfig{
+				{
+					MachineType:    "n1-standard-2",
+					NumNodes:       2,
+					ReleaseChannel: "regular",
+					Preemptible:    true,
+					EnableIPAlias:  true,
+					Private:        &privateClusterConfig{MasterIPv4CIDR: "172.16.0.0/28"},
+					NodePools: []nodePoolConfig{
+						{Name: "system"},
+						{
+							Name:        "workload",
+							MachineType: "n1-standard-8",
+							DiskSizeGb:  200,
+							DiskType:    "pd-ssd",
+							Autoscaling: &autoscalingConfig{MinNodes: 1, MaxNodes: 10},
+							Labels:      map[string]string{"workload": "perf"},
+							Taints:      []*container.NodeTaint{taint},
+						},
+					},
+					Readiness: &readinessConfig{Timeout: 10 * time.Minute, SystemPods: []string{"kube-dns"}},
+				},
+			},
+		}},
+	}
+
+	conf, err := common.ParseConfig("test-configs.yaml")
+	if err != nil {
+		t.Fatal("could not parse config")
+	}
+	config, err := ConfigConverter(conf.Resources[2].Config.Content)
+	if err != nil {
+		t.Fatalf("cannot parse object: %v", err)
+	}
+	if !reflect.DeepEqual(expected, *config.(*resourceConfigs)) {
+		t.Errorf("expected %v, got %v", expected, *config.(*resourceConfigs))
+	}
+}
+
+func TestNewClusterRequest(t *testing.T) {
+	scopes := []string{"https://www.googleapis.com/auth/cloud-platform"}
+	taint := &container.NodeTaint{Key: "dedicated", Value: "perf", Effect: taintNoSchedule}
+
+	var testCases = []struct {
+		name     string
+		config   clusterConfig
+		expected *container.Cluster
+	}{
+		{
+			name:   "default node pool",
+			config: clusterConfig{MachineType: "n1-standard-2", NumNodes: 3, Scopes: scopes, DiskSizeGb: 50, Preemptible: true},
+			expected: &container.Cluster{
+				Name:                  "name",
+				InitialClusterVersion: "1.15.9-gke.24",
+				InitialNodeCount:      3,
+				NodeConfig: &container.NodeConfig{
+					MachineType: "n1-standard-2",
+					OauthScopes: scopes,
+					DiskSizeGb:  50,
+					Preemptible: true,
+				},
+			},
+		},
+		{
+			name:   "autoscaling default node pool",
+			config: clusterConfig{MachineType: "n1-standard-2", NumNodes: 3, Autoscaling: &autoscalingConfig{MinNodes: 1, MaxNodes: 5}},
+			expected: &container.Cluster{
+				Name:                  "name",
+				InitialClusterVersion: "1.15.9-gke.24",
+				NodePools: []*container.NodePool{{
+					Name:             defaultNodePool,
+					InitialNodeCount: 3,
+					Config:           &container.NodeConfig{MachineType: "n1-standard-2"},
+					Autoscaling:      &container.NodePoolAutoscaling{Enabled: true, MinNodeCount: 1, MaxNodeCount: 5},
+				}},
+			},
+		},
+		{
+			name: "node pools",
+			config: clusterConfig{
+				MachineType: "n1-standard-2",
+				NumNodes:    2,
+				Scopes:      scopes,
+				DiskType:    "pd-standard",
+				NodePools: []nodePoolConfig{
+					{Name: "system"},
+					{
+						Name:        "workload",
+						MachineType: "n1-standard-8",
+						NumNodes:    4,
+						DiskSizeGb:  200,
+						DiskType:    "pd-ssd",
+						Preemptible: true,
+						Autoscaling: &autoscalingConfig{MinNodes: 0, MaxNodes: 10},
+						Labels:      map[string]string{"workload": "perf"},
+						Taints:      []*container.NodeTaint{taint},
+					},
+				},
+			},
+			expected: &container.Cluster{
+				Name:                  "name",
+				InitialClusterVersion: "1.15.9-gke.24",
+				NodePools: []*container.NodePool{
+					{
+						Name:             "system",
+						InitialNodeCount: 2,
+						Config:           &container.NodeConfig{MachineType: "n1-standard-2", OauthScopes: scopes, DiskType: "pd-standard"},
+					},
+					{
+						Name:             "workload",
+						InitialNodeCount: 4,
+						Config: &container.NodeConfig{
+							MachineType: "n1-standard-8",
+							OauthScopes: scopes,
+							DiskSizeGb:  200,
+							DiskType:    "pd-ssd",
+							Preemptible: true,
+							Labels:      map[string]string{"workload": "perf"},
+							Taints:      []*container.NodeTaint{taint},
+						},
+						Autoscaling: &container.NodePoolAutoscaling{Enabled: true, MaxNodeCount: 10},
+					},
+				},
+			},
+		},
+		{
+			name: "private cluster with ip aliases and release channel",
+			config: clusterConfig{
+				NumNodes:         1,
+				ReleaseChannel:   "rapid",
+				EnableIPAlias:    true,
+				ClusterIPv4CIDR:  "/16",
+				ServicesIPv4CIDR: "/22",
+				Private:          &privateClusterConfig{MasterIPv4CIDR: "172.16.0.0/28", EnablePrivateEndpoint: true},
+			},
+			expected: &container.Cluster{
+				Name:                  "name",
+				InitialClusterVersion: "1.15.9-gke.24",
+				InitialNodeCount:      1,
+				NodeConfig:            &container.NodeConfig{},
+				ReleaseChannel:        &container.ReleaseChannel{Channel: releaseChannelRapid},
+				IpAllocationPolicy: &container.IPAllocationPolicy{
+					UseIpAliases:          true,
+					ClusterIpv4CidrBlock:  "/16",
+					ServicesIpv4CidrBlock: "/22",
+				},
+				PrivateClusterConfig: &container.PrivateClusterConfig{
+					EnablePrivateNodes:    true,
+					EnablePrivateEndpoint: true,
+					MasterIpv4CidrBlock:   "172.16.0.0/28",
+				},
+			},
+		},
+		{
+			name:   "workload identity and client certificate",
+			config: clusterConfig{NumNodes: 1, EnableWorkloadIdentity: true, EnableClientCertificate: true},
+			expected: &container.Cluster{
+				Name:                   "name",
+				InitialClusterVersion:  "1.15.9-gke.24",
+				InitialNodeCount:       1,
+				NodeConfig:             &container.NodeConfig{},
+				WorkloadIdentityConfig: &container.WorkloadIdentityConfig{IdentityNamespace: "project.svc.id.goog"},
+				MasterAuth: &container.MasterAuth{
+					ClientCertificateConfig: &container.ClientCertificateConfig{IssueClientCertificate: true},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := newClusterRequest("project", "name", "1.15.9-gke.24", tc.config)
+			if !reflect.DeepEqual(request.Cluster, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, request.Cluster)
+			}
+		})
+	}
+}
+
+func TestClusterConfigValidate(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		config clusterConfig
+		err    string
+	}{
+		{
+			name: "valid",
+			config: clusterConfig{
+				ReleaseChannel: "Stable",
+				EnableIPAlias:  true,
+				Private:        &privateClusterConfig{},
+				Autoscaling:    &autoscalingConfig{MinNodes: 1, MaxNodes: 1},
+				NodePools: []nodePoolConfig{
+					{Name: "a", Taints: []*container.NodeTaint{{Key: "k", Effect: taintNoExecute}}},
+					{Name: "b", Autoscaling: &autoscalingConfig{MaxNodes: 3}},
+				},
+			},
+		},
+		{
+			name:   "unknown release channel",
+			config: clusterConfig{ReleaseChannel: "nightly"},
+			err:    "unknown release channel nightly",
+		},
+		{
+			name:   "private cluster without ip aliases",
+			config: clusterConfig{Private: &privateClusterConfig{}},
+			err:    "private clusters require ip aliases",
+		},
+		{
+			name:   "ip ranges without ip aliases",
+			config: clusterConfig{ClusterIPv4CIDR: "/16"},
+			err:    "cluster and services ip ranges require ip aliases",
+		},
+		{
+			name:   "invalid autoscaling bounds",
+			config: clusterConfig{Autoscaling: &autoscalingConfig{MinNodes: 3, MaxNodes: 2}},
+			err:    "invalid autoscaling bounds [3, 2]",
+		},
+		{
+			name:   "unnamed node pool",
+			config: clusterConfig{NodePools: []nodePoolConfig{{}}},
+			err:    "node pools must be named",
+		},
+		{
+			name:   "duplicate node pools",
+			config: clusterConfig{NodePools: []nodePoolConfig{{Name: "a"}, {Name: "a"}}},
+			err:    "node pool a is defined more than once",
+		},
+		{
+			name:   "invalid node pool autoscaling bounds",
+			config: clusterConfig{NodePools: []nodePoolConfig{{Name: "a", Autoscaling: &autoscalingConfig{}}}},
+			err:    "node pool a: invalid autoscaling bounds [0, 0]",
+		},
+		{
+			name:   "taint without key",
+			config: clusterConfig{NodePools: []nodePoolConfig{{Name: "a", Taints: []*container.NodeTaint{{Effect: taintNoSchedule}}}}},
+			err:    "node pool a: taints must have a key",
+		},
+		{
+			name:   "unknown taint effect",
+			config: clusterConfig{NodePools: []nodePoolConfig{{Name: "a", Taints: []*container.NodeTaint{{Key: "k", Effect: "NoSchedule"}}}}},
+			err:    "node pool a: unknown effect NoSchedule of taint k",
+		},
+		{
+			name:   "negative readiness timeout",
+			config: clusterConfig{Readiness: &readinessConfig{Timeout: -time.Minute}},
+			err:    "invalid readiness timeout -1m0s",
+		},
+		{
+			name:   "empty system pod",
+			config: clusterConfig{Readiness: &readinessConfig{SystemPods: []string{""}}},
+			err:    "required system pods must not be empty",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var errString string
+			if err := tc.config.validate(); err != nil {
+				errString = err.Error()
+			}
+			if errString != tc.err {
+				t.Errorf("expected error %q, got %q", tc.err, errString)
+			}
+		})
+	}
+}
+
+func TestConfigConverterInvalidCluster(t *testing.T) {
+	_, err := ConfigConverter(`type1:
+- clusters:
+  - numnodes: 1
+  - private: {}
+`)
+	expected := "invalid cluster 1 of project 0 of type1: private clusters require ip aliases"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
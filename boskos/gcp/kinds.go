@@ -0,0 +1,143 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+	pubsub "google.golang.org/api/pubsub/v1"
+	storage "google.golang.org/api/storage/v1"
+)
+
+const (
+	// Kinds of resources constructed in projects besides clusters and vms.
+	bucketKind   = "bucket"
+	topicKind    = "topic"
+	addressKind  = "address"
+	firewallKind = "firewall"
+)
+
+// kindConfig is a resource of a project other than a cluster or a vm, such as a bucket or a firewall rule.
+type kindConfig struct {
+	// Kind is one of the kinds returned by newResourceKinds.
+	Kind string `json:"kind"`
+	// Location is the location of kinds that have one, such as the region of an address.
+	Location string `json:"location,omitempty"`
+	// Options are specific to the kind.
+	Options map[string]string `json:"options,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// KindInfo stores information about a resource of another kind than clusters and vms. The zone is the location of
+// the resource, if any.
+type KindInfo struct {
+	Kind string `json:"kind"`
+	InstanceInfo
+	// Description tells how the resource is used, such as the url of a bucket or the ip of an address.
+	Description string `json:"description,omitempty"`
+}
+
+// resourceKind creates and deletes resources of a kind. When the creation of a resource fails after it started, the
+// resource is returned along with the error, such that it can be deleted.
+type resourceKind interface {
+	// validate checks a config when it is parsed, and must not use GCP.
+	validate(kindConfig) error
+	create(context.Context, string, kindConfig) (*InstanceInfo, error)
+	delete(context.Context, string, InstanceInfo) error
+	// describe returns the description of a resource, saved in its info once it is created.
+	describe(context.Context, string, InstanceInfo) (string, error)
+}
+
+// newResourceKinds returns the kinds of resources that can be configured, by name. New kinds are added here. Kinds
+// returned for nil services only validate configs.
+func newResourceKinds(storageService *storage.Service, pubsubService *pubsub.Service, computeService *compute.Service) map[string]resourceKind {
+	return map[string]resourceKind{
+		bucketKind:   &bucketResource{storageService},
+		topicKind:    &topicResource{pubsubService},
+		addressKind:  &addressResource{computeService},
+		firewallKind: &firewallResource{computeService},
+	}
+}
+
+// validateKindConfig checks that a config is of a known kind, and valid for it.
+func validateKindConfig(config kindConfig) error {
+	kind, ok := newResourceKinds(nil, nil, nil)[config.Kind]
+	if !ok {
+		return fmt.Errorf("unknown kind %q", config.Kind)
+	}
+	return kind.validate(config)
+}
+
+// checkOptions checks that the options of a config are among the supported ones.
+func checkOptions(config kindConfig, supported ...string) error {
+	var unknown []string
+	for option := range config.Options {
+		found := false
+		for _, s := range supported {
+			if option == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, option)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown options %s for %s", strings.Join(unknown, ", "), config.Kind)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, ignoring empty values.
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// waitForComputeOperation waits for a global or regional operation of the GCE API, which get returns.
+func waitForComputeOperation(ctx context.Context, get func() (*compute.Operation, error)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(defaultSleepTime):
+			op, err := get()
+			if err != nil {
+				return err
+			}
+			if op.Status != operationDone {
+				logrus.Infof("operation %s status is %s", op.Name, op.Status)
+				continue
+			}
+			if op.Error != nil && len(op.Error.Errors) > 0 {
+				return fmt.Errorf("operation %s failed: %s", op.Name, op.Error.Errors[0].Message)
+			}
+			return nil
+		}
+	}
+}
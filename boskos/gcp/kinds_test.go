@@ -0,0 +1,270 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+
+	"k8s.io/test-infra/boskos/common"
+)
+
+func TestParseKindConfig(t *testing.T) {
+	expected := resourceConfigs{
+		"type1": {{
+			Resources: []kindConfig{
+				{
+					Kind:     bucketKind,
+					Location: "us-central1",
+					Options:  map[string]string{"storageclass": "REGIONAL"},
+					Labels:   map[string]string{"team": "perf"},
+				},
+				{Kind: topicKind},
+				{Kind: addressKind, Location: "us-central1"},
+				{
+					Kind: firewallKind,
+					Options: map[string]string{
+						"network":      "test-network",
+						"allow":        "tcp:22,tcp:8080-8090,icmp",
+						"sourceranges": "10.0.0.0/8",
+						"targettags":   "http-server",
+					},
+				},
+			},
+		}},
+	}
+
+	conf, err := common.ParseConfig("test-configs.yaml")
+	if err != nil {
+		t.Fatal("could not parse config")
+	}
+	config, err := ConfigConverter(conf.Resources[4].Config.Content)
+	if err != nil {
+		t.Fatalf("cannot parse object: %v", err)
+	}
+	if !reflect.DeepEqual(expected, *config.(*resourceConfigs)) {
+		t.Errorf("expected %v, got %v", expected, *config.(*resourceConfigs))
+	}
+
+	_, err = ConfigConverter(`type1:
+- resources:
+  - kind: topic
+  - kind: queue
+`)
+	if expected := `invalid resource 1 of project 0 of type1: unknown kind "queue"`; err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestValidateKindConfig(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		config kindConfig
+		err    string
+	}{
+		{
+			name:   "bucket",
+			config: kindConfig{Kind: bucketKind, Location: "US", Options: map[string]string{"storageclass": "MULTI_REGIONAL"}},
+		},
+		{
+			name:   "unknown options",
+			config: kindConfig{Kind: bucketKind, Options: map[string]string{"versioning": "true", "acl": "private"}},
+			err:    "unknown options acl, versioning for bucket",
+		},
+		{
+			name:   "topic with location",
+			config: kindConfig{Kind: topicKind, Location: "us-central1"},
+			err:    "topics are global, got location us-central1",
+		},
+		{
+			name:   "global address",
+			config: kindConfig{Kind: addressKind, Location: globalLocation},
+		},
+		{
+			name:   "address in a zone",
+			config: kindConfig{Kind: addressKind, Location: "us-central1-a"},
+			err:    "location of addresses must be a region or global, got us-central1-a",
+		},
+		{
+			name:   "address with labels",
+			config: kindConfig{Kind: addressKind, Labels: map[string]string{"team": "perf"}},
+			err:    "addresses do not support labels",
+		},
+		{
+			name:   "firewall without traffic",
+			config: kindConfig{Kind: firewallKind, Options: map[string]string{"network": "test-network"}},
+			err:    "firewall rules need traffic to allow",
+		},
+		{
+			name:   "firewall with invalid rule",
+			config: kindConfig{Kind: firewallKind, Options: map[string]string{"allow": "tcp:"}},
+			err:    `invalid rule "tcp:", expected protocol or protocol:ports`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var errString string
+			if err := validateKindConfig(tc.config); err != nil {
+				errString = err.Error()
+			}
+			if errString != tc.err {
+				t.Errorf("expected error %q, got %q", tc.err, errString)
+			}
+		})
+	}
+}
+
+func TestNewFirewall(t *testing.T) {
+	config := kindConfig{
+		Kind:    firewallKind,
+		Options: map[string]string{"allow": "tcp:22, icmp, tcp:8080-8090", "sourceranges": "10.0.0.0/8,", "targettags": "a,b"},
+	}
+	firewall, err := newFirewall(config, "p", "fw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &compute.Firewall{
+		Name:    "fw",
+		Network: "projects/p/global/networks/default",
+		Allowed: []*compute.FirewallAllowed{
+			{IPProtocol: "tcp", Ports: []string{"22", "8080-8090"}},
+			{IPProtocol: "icmp"},
+		},
+		SourceRanges: []string{"10.0.0.0/8"},
+		TargetTags:   []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(firewall, expected) {
+		t.Errorf("expected firewall %+v, got %+v", expected, firewall)
+	}
+}
+
+func TestConstructKinds(t *testing.T) {
+	f := NewFakeGCP()
+	SetClient(NewFakeClient(f))
+	defer SetClient(nil)
+	ctx := context.Background()
+	res := common.Resource{Name: "env", Type: "gcp-env", Owner: "mason"}
+	types := func() common.TypeToResources {
+		return common.TypeToResources{"project": {{Name: "p"}}}
+	}
+	rc := resourceConfigs{
+		"project": {{
+			Vms: []virtualMachineConfig{{}},
+			Resources: []kindConfig{
+				{Kind: bucketKind, Location: "US", Labels: map[string]string{"team": "perf"}},
+				{Kind: topicKind},
+			},
+		}},
+	}
+
+	userData, info, err := rc.construct(ctx, res, types())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := (*info)["p"].Resources
+	if len(resources) != 2 {
+		t.Fatalf("expected 2 resources, got %v", resources)
+	}
+	for _, r := range resources {
+		if expected := "fake://p/" + r.Kind + "/" + r.Name; r.Description != expected {
+			t.Errorf("expected description %s, got %s", expected, r.Description)
+		}
+	}
+	for _, i := range f.Instances() {
+		if i.Kind == bucketKind && (i.Zone != "US" || i.Labels["team"] != "perf" || i.Labels[resourceLabel] != "env") {
+			t.Errorf("unexpected bucket %+v", i)
+		}
+	}
+
+	// The resources are recorded in the user data, and deleted by Cleanup.
+	res.UserData = userData
+	if err := Cleanup(ctx, res); err != nil {
+		t.Fatal(err)
+	}
+	if instances := f.Instances(); len(instances) != 0 {
+		t.Errorf("expected every instance to be deleted, got %v", instances)
+	}
+
+	// Resources that cannot be described are rolled back with the others.
+	f.Fail(FakeFailure{Method: FakeGet, Kind: topicKind})
+	_, _, err = rc.construct(ctx, res, types())
+	if err == nil || !strings.Contains(err.Error(), "topic 1 on project p: ") {
+		t.Errorf("expected the topic to fail, got %v", err)
+	}
+	if instances := f.Instances(); len(instances) != 0 {
+		t.Errorf("expected every instance to be rolled back, got %v", instances)
+	}
+
+	_, _, err = resourceConfigs{"project": {{Resources: []kindConfig{{Kind: "queue"}}}}}.construct(ctx, res, types())
+	if expected := `unknown kind "queue" of resource 0 on project p`; err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestBucketDelete(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/b/bucket/o"):
+			if r.URL.Query().Get("versions") != "true" {
+				t.Errorf("expected every version of the objects to be listed")
+			}
+			json.NewEncoder(w).Encode(&storage.Objects{Items: []*storage.Object{
+				{Name: "a", Generation: 1},
+				{Name: "a", Generation: 2},
+				{Name: "gone", Generation: 1},
+			}})
+		case r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/o/gone"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	service, err := storage.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bucketResource{service}
+	if err := b.delete(context.Background(), "p", InstanceInfo{Name: "bucket"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"GET /b/bucket/o", "DELETE /b/bucket/o/a", "DELETE /b/bucket/o/a", "DELETE /b/bucket/o/gone", "DELETE /b/bucket"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+}
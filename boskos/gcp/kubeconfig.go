@@ -0,0 +1,285 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	container "google.golang.org/api/container/v1beta1"
+	clientapi "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+const (
+	// gcpAuthProvider is the client-go auth provider getting tokens from the google application default credentials.
+	gcpAuthProvider = "gcp"
+	kubeConfigMode  = 0600
+)
+
+// kubeConfigLock serializes the merges into kubeconfig files within the process.
+var kubeConfigLock sync.Mutex
+
+// contextName is the name of the kubeconfig context of a cluster, which is the one used by gcloud.
+func contextName(project string, info InstanceInfo) string {
+	return fmt.Sprintf("gke_%s_%s_%s", project, info.Zone, info.Name)
+}
+
+// clusterCertificates decodes the certificate authority of a cluster described by the GKE API, and its client
+// certificate and key if it issues client certificates.
+func clusterCertificates(cluster *container.Cluster) (ca, cert, key []byte, err error) {
+	if cluster.MasterAuth == nil || cluster.MasterAuth.ClusterCaCertificate == "" {
+		return nil, nil, nil, fmt.Errorf("cluster %s has no certificate authority", cluster.Name)
+	}
+	if cluster.Endpoint == "" {
+		return nil, nil, nil, fmt.Errorf("cluster %s has no endpoint", cluster.Name)
+	}
+	if ca, err = base64.StdEncoding.DecodeString(cluster.MasterAuth.ClusterCaCertificate); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid certificate authority of cluster %s: %v", cluster.Name, err)
+	}
+	if cluster.MasterAuth.ClientCertificate == "" || cluster.MasterAuth.ClientKey == "" {
+		return ca, nil, nil, nil
+	}
+	if cert, err = base64.StdEncoding.DecodeString(cluster.MasterAuth.ClientCertificate); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid client certificate of cluster %s: %v", cluster.Name, err)
+	}
+	if key, err = base64.StdEncoding.DecodeString(cluster.MasterAuth.ClientKey); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid client key of cluster %s: %v", cluster.Name, err)
+	}
+	return ca, cert, key, nil
+}
+
+// newKubeConfig creates a kubeconfig with a single context for a cluster, named after the context. Clusters issuing
+// client certificates are accessed with them. Other clusters are accessed with the token if given, or with the gcp
+// auth provider otherwise, like with gcloud.
+func newKubeConfig(name string, cluster *container.Cluster, token *oauth2.Token) (*clientapi.Config, error) {
+	ca, cert, key, err := clusterCertificates(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	var authInfo clientapi.AuthInfo
+	switch {
+	case cert != nil:
+		authInfo.ClientCertificateData, authInfo.ClientKeyData = cert, key
+	case token != nil:
+		authInfo.Token = token.AccessToken
+	default:
+		authInfo.AuthProvider = &clientapi.AuthProviderConfig{Name: gcpAuthProvider}
+	}
+
+	config := newEmptyKubeConfig()
+	config.Clusters = []clientapi.NamedCluster{{
+		Name: name,
+		Cluster: clientapi.Cluster{
+			Server:                   "https://" + cluster.Endpoint,
+			CertificateAuthorityData: ca,
+		},
+	}}
+	config.AuthInfos = []clientapi.NamedAuthInfo{{Name: name, AuthInfo: authInfo}}
+	config.Contexts = []clientapi.NamedContext{{Name: name, Context: clientapi.Context{Cluster: name, AuthInfo: name}}}
+	config.CurrentContext = name
+	return config, nil
+}
+
+func newEmptyKubeConfig() *clientapi.Config {
+	return &clientapi.Config{APIVersion: "v1", Kind: "Config"}
+}
+
+// mergeKubeConfig adds the entries of a kubeconfig to another, replacing the entries with the same names. The current
+// context is the one of the added kubeconfig, if any.
+func mergeKubeConfig(dst, src *clientapi.Config) {
+	for _, c := range src.Clusters {
+		i := 0
+		for i < len(dst.Clusters) && dst.Clusters[i].Name != c.Name {
+			i++
+		}
+		if i == len(dst.Clusters) {
+			dst.Clusters = append(dst.Clusters, c)
+		} else {
+			dst.Clusters[i] = c
+		}
+	}
+	for _, a := range src.AuthInfos {
+		i := 0
+		for i < len(dst.AuthInfos) && dst.AuthInfos[i].Name != a.Name {
+			i++
+		}
+		if i == len(dst.AuthInfos) {
+			dst.AuthInfos = append(dst.AuthInfos, a)
+		} else {
+			dst.AuthInfos[i] = a
+		}
+	}
+	for _, c := range src.Contexts {
+		i := 0
+		for i < len(dst.Contexts) && dst.Contexts[i].Name != c.Name {
+			i++
+		}
+		if i == len(dst.Contexts) {
+			dst.Contexts = append(dst.Contexts, c)
+		} else {
+			dst.Contexts[i] = c
+		}
+	}
+	if src.CurrentContext != "" {
+		dst.CurrentContext = src.CurrentContext
+	}
+}
+
+// loadKubeConfig reads a kubeconfig file, which is empty if it does not exist.
+func loadKubeConfig(path string) (*clientapi.Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return newEmptyKubeConfig(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	config := newEmptyKubeConfig()
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// marshalKubeConfig encodes a kubeconfig to yaml.
+func marshalKubeConfig(config *clientapi.Config) ([]byte, error) {
+	return yaml.Marshal(config)
+}
+
+// mergeKubeConfigFile merges a kubeconfig into a file, keeping the entries of the file that are not replaced. The
+// file is created if needed, and replaced atomically such that readers never see a partial kubeconfig.
+func mergeKubeConfigFile(path string, config *clientapi.Config) error {
+	kubeConfigLock.Lock()
+	defer kubeConfigLock.Unlock()
+
+	existing, err := loadKubeConfig(path)
+	if err != nil {
+		return fmt.Errorf("unable to load kubeconfig %s: %v", path, err)
+	}
+	mergeKubeConfig(existing, config)
+	data, err := marshalKubeConfig(existing)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(kubeConfigMode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// UseTokenAuth makes kubeconfigs access clusters without client certificates with an access token of the client
+// credentials, rather than with the gcp auth provider. Such kubeconfigs need no google credentials, but their token
+// expires after an hour.
+func (c *Client) UseTokenAuth() {
+	c.tokenAuth = true
+}
+
+// kubeConfig creates a kubeconfig with a context for every cluster of a resource, based on their description by the
+// GKE API. The current context is the one of the first cluster.
+func (c *Client) kubeConfig(ctx context.Context, info ResourceInfo) (*clientapi.Config, error) {
+	var token *oauth2.Token
+	if c.tokenAuth {
+		var err error
+		if token, err = c.tokenSource.Token(); err != nil {
+			return nil, fmt.Errorf("unable to get access token: %v", err)
+		}
+	}
+
+	var projects []string
+	for project := range info {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	config := newEmptyKubeConfig()
+	var current string
+	for _, project := range projects {
+		for _, ci := range info[project].Clusters {
+			cluster, err := c.gke.get(ctx, project, ci)
+			if err != nil {
+				logrus.WithError(err).Errorf("unable to get cluster %s in zone %s for project %s", ci.Name, ci.Zone, project)
+				return nil, err
+			}
+			clusterConfig, err := newKubeConfig(contextName(project, ci), cluster, token)
+			if err != nil {
+				return nil, err
+			}
+			if current == "" {
+				current = clusterConfig.CurrentContext
+			}
+			mergeKubeConfig(config, clusterConfig)
+		}
+	}
+	config.CurrentContext = current
+	return config, nil
+}
+
+// Install kubeconfig for a given resource. It will create only one file with all contexts, merged with the existing
+// content of the file.
+func (r ResourceInfo) Install(kubeconfig string) error {
+	gcpClientLock.RLock()
+	client := gcpClient
+	gcpClientLock.RUnlock()
+
+	if client == nil {
+		err := fmt.Errorf("client not set")
+		logrus.WithError(err).Error("client not set; please call SetClient")
+		return err
+	}
+
+	config, err := client.kubeConfig(context.Background(), r)
+	if err != nil {
+		logrus.WithError(err).Errorf("failed to create kubeconfig")
+		return err
+	}
+	if len(config.Contexts) == 0 {
+		return nil
+	}
+	return mergeKubeConfigFile(kubeconfig, config)
+}
+
+// SetKubeConfig saves kube config from a given cluster to the given location, merged with its existing content.
+// The zone of a regional cluster is its region.
+func SetKubeConfig(project, zone, cluster, kubeconfig string) error {
+	info := ResourceInfo{project: ProjectInfo{Clusters: []InstanceInfo{{Name: cluster, Zone: zone}}}}
+	return info.Install(kubeconfig)
+}
@@ -0,0 +1,274 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/oauth2"
+	container "google.golang.org/api/container/v1beta1"
+	clientapi "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	"k8s.io/test-infra/boskos/common"
+)
+
+func encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func findCluster(config *clientapi.Config, name string) *clientapi.Cluster {
+	for i := range config.Clusters {
+		if config.Clusters[i].Name == name {
+			return &config.Clusters[i].Cluster
+		}
+	}
+	return nil
+}
+
+func findAuthInfo(config *clientapi.Config, name string) *clientapi.AuthInfo {
+	for i := range config.AuthInfos {
+		if config.AuthInfos[i].Name == name {
+			return &config.AuthInfos[i].AuthInfo
+		}
+	}
+	return nil
+}
+
+func contextNames(config *clientapi.Config) []string {
+	var names []string
+	for _, c := range config.Contexts {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestNewKubeConfig(t *testing.T) {
+	cluster := func(auth *container.MasterAuth) *container.Cluster {
+		return &container.Cluster{Name: "c", Endpoint: "1.2.3.4", MasterAuth: auth}
+	}
+	var testCases = []struct {
+		name     string
+		cluster  *container.Cluster
+		token    *oauth2.Token
+		expected clientapi.AuthInfo
+		err      string
+	}{
+		{
+			name:     "auth provider",
+			cluster:  cluster(&container.MasterAuth{ClusterCaCertificate: encode("ca")}),
+			expected: clientapi.AuthInfo{AuthProvider: &clientapi.AuthProviderConfig{Name: gcpAuthProvider}},
+		},
+		{
+			name:     "token",
+			cluster:  cluster(&container.MasterAuth{ClusterCaCertificate: encode("ca")}),
+			token:    &oauth2.Token{AccessToken: "token"},
+			expected: clientapi.AuthInfo{Token: "token"},
+		},
+		{
+			name: "client certificate",
+			cluster: cluster(&container.MasterAuth{
+				ClusterCaCertificate: encode("ca"),
+				ClientCertificate:    encode("cert"),
+				ClientKey:            encode("key"),
+			}),
+			token:    &oauth2.Token{AccessToken: "token"},
+			expected: clientapi.AuthInfo{ClientCertificateData: []byte("cert"), ClientKeyData: []byte("key")},
+		},
+		{
+			name:    "no certificate authority",
+			cluster: cluster(nil),
+			err:     "cluster c has no certificate authority",
+		},
+		{
+			name:    "invalid certificate authority",
+			cluster: cluster(&container.MasterAuth{ClusterCaCertificate: "!"}),
+			err:     "invalid certificate authority of cluster c: illegal base64 data at input byte 0",
+		},
+		{
+			name:    "no endpoint",
+			cluster: &container.Cluster{Name: "c", MasterAuth: &container.MasterAuth{ClusterCaCertificate: encode("ca")}},
+			err:     "cluster c has no endpoint",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := newKubeConfig("ctx", tc.cluster, tc.token)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := &clientapi.Config{
+				APIVersion:     "v1",
+				Kind:           "Config",
+				Clusters:       []clientapi.NamedCluster{{Name: "ctx", Cluster: clientapi.Cluster{Server: "https://1.2.3.4", CertificateAuthorityData: []byte("ca")}}},
+				AuthInfos:      []clientapi.NamedAuthInfo{{Name: "ctx", AuthInfo: tc.expected}},
+				Contexts:       []clientapi.NamedContext{{Name: "ctx", Context: clientapi.Context{Cluster: "ctx", AuthInfo: "ctx"}}},
+				CurrentContext: "ctx",
+			}
+			if !reflect.DeepEqual(config, expected) {
+				t.Errorf("expected %+v, got %+v", expected, config)
+			}
+		})
+	}
+}
+
+func TestMergeKubeConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".kube", "config")
+
+	newConfig := func(name string) *clientapi.Config {
+		config := newEmptyKubeConfig()
+		config.Clusters = []clientapi.NamedCluster{{Name: name, Cluster: clientapi.Cluster{Server: "https://" + name}}}
+		config.AuthInfos = []clientapi.NamedAuthInfo{{Name: name, AuthInfo: clientapi.AuthInfo{Token: name}}}
+		config.Contexts = []clientapi.NamedContext{{Name: name, Context: clientapi.Context{Cluster: name, AuthInfo: name}}}
+		config.CurrentContext = name
+		return config
+	}
+
+	// Existing entries are kept, and entries with the same names are replaced.
+	existing := newConfig("existing")
+	existing.Preferences.Colors = true
+	replaced := newConfig("replaced")
+	replaced.AuthInfos[0].AuthInfo.Token = "old"
+	mergeKubeConfig(existing, replaced)
+	existing.CurrentContext = "existing"
+	data, err := yaml.Marshal(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := mergeKubeConfigFile(path, newConfig(fmt.Sprintf("new-%d", i))); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := mergeKubeConfigFile(path, newConfig("replaced")); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadKubeConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contexts := contextNames(config)
+	expected := []string{"existing", "new-0", "new-1", "new-2", "new-3", "new-4", "new-5", "new-6", "new-7", "new-8", "new-9", "replaced"}
+	if !reflect.DeepEqual(contexts, expected) {
+		t.Errorf("expected contexts %v, got %v", expected, contexts)
+	}
+	if a := findAuthInfo(config, "replaced"); a == nil || a.Token != "replaced" {
+		t.Errorf("expected replaced token, got %+v", a)
+	}
+	if len(config.AuthInfos) != len(expected) || len(config.Clusters) != len(expected) {
+		t.Errorf("expected %d users and clusters, got %d and %d", len(expected), len(config.AuthInfos), len(config.Clusters))
+	}
+	if config.CurrentContext != "replaced" || !config.Preferences.Colors {
+		t.Errorf("expected current context replaced and preferences kept, got %s and %+v", config.CurrentContext, config.Preferences)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != kubeConfigMode {
+		t.Errorf("expected mode %o, got %o", kubeConfigMode, info.Mode().Perm())
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected temporary files to be removed, got %d files", len(files))
+	}
+}
+
+func TestInstall(t *testing.T) {
+	f := NewFakeGCP()
+	client := NewFakeClient(f)
+	SetClient(client)
+	rc := resourceConfigs{"project": {{Clusters: []clusterConfig{{}, {}}}}}
+	_, info, err := rc.construct(context.Background(), common.Resource{Name: "test"}, common.TypeToResources{"project": {{Name: "p"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+
+	client.UseTokenAuth()
+	if err := info.Install(path); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadKubeConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := (*info)["p"].Clusters
+	if len(config.Contexts) != 2 {
+		t.Fatalf("expected 2 contexts, got %v", config.Contexts)
+	}
+	for _, i := range f.Instances() {
+		name := contextName("p", InstanceInfo{Name: i.Name, Zone: i.Zone})
+		if c := findCluster(config, name); c == nil || c.Server != "https://"+i.Endpoint || string(c.CertificateAuthorityData) != "ca of "+i.Name {
+			t.Errorf("unexpected cluster %s: %+v", name, c)
+		}
+		if a := findAuthInfo(config, name); a == nil || a.Token != FakeToken {
+			t.Errorf("expected token auth for %s, got %+v", name, a)
+		}
+	}
+	if first := contextName("p", clusters[0]); config.CurrentContext != first {
+		t.Errorf("expected current context %s, got %s", first, config.CurrentContext)
+	}
+
+	f.Fail(FakeFailure{Method: FakeGet})
+	if err := info.Install(path); err == nil {
+		t.Error("expected install to fail")
+	}
+}
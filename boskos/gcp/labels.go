@@ -0,0 +1,89 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/test-infra/boskos/common"
+)
+
+const (
+	// Labels set on the clusters and vms constructed for a boskos resource.
+	resourceLabel = "boskos-resource"
+	typeLabel     = "boskos-type"
+	ownerLabel    = "boskos-owner"
+	// createdLabel is the creation time, in seconds since the epoch.
+	createdLabel = "boskos-created"
+
+	// gkeNodeLabel is set by GKE on the vms of the nodes of clusters, which get the labels of their cluster.
+	gkeNodeLabel = "goog-gke-node"
+
+	// Defined in https://cloud.google.com/compute/docs/labeling-resources
+	maxLabelLength = 63
+)
+
+// labelValue turns a string into a valid label value, made of lowercase letters, digits, dashes and underscores.
+func labelValue(s string) string {
+	value := []rune(strings.ToLower(s))
+	for i, r := range value {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			value[i] = '-'
+		}
+	}
+	if len(value) > maxLabelLength {
+		value = value[:maxLabelLength]
+	}
+	return string(value)
+}
+
+// resourceLabels returns the labels identifying the instances constructed for a resource.
+func resourceLabels(res common.Resource, created time.Time) map[string]string {
+	return map[string]string{
+		resourceLabel: labelValue(res.Name),
+		typeLabel:     labelValue(res.Type),
+		ownerLabel:    labelValue(res.Owner),
+		createdLabel:  strconv.FormatInt(created.Unix(), 10),
+	}
+}
+
+// withLabels returns the labels of an instance along with additional labels, which take precedence.
+func withLabels(labels, additional map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range additional {
+		merged[k] = v
+	}
+	return merged
+}
+
+// labeledInstance is an instance listed along with its labels.
+type labeledInstance struct {
+	InstanceInfo
+	Labels map[string]string
+}
+
+// created returns the creation time recorded in the labels of an instance.
+func (i labeledInstance) created() (time.Time, bool) {
+	seconds, err := strconv.ParseInt(i.Labels[createdLabel], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
@@ -0,0 +1,170 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	clientapi "k8s.io/client-go/tools/clientcmd/api/v1"
+	"k8s.io/test-infra/boskos/client"
+	"k8s.io/test-infra/boskos/common"
+	"k8s.io/test-infra/boskos/crds"
+	"k8s.io/test-infra/boskos/handlers"
+	"k8s.io/test-infra/boskos/mason"
+	"k8s.io/test-infra/boskos/ranch"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	masonWaitPeriod = 10 * time.Millisecond
+	masonTimeout    = 30 * time.Second
+)
+
+// localBoskos is a boskos server storing resources in memory.
+type localBoskos struct {
+	storage *ranch.Storage
+	server  *httptest.Server
+}
+
+func newLocalBoskos(t *testing.T, resources []common.Resource, lifecycles []common.DynamicResourceLifeCycle) *localBoskos {
+	t.Helper()
+	s := ranch.NewStorage(context.Background(), fakectrlruntimeclient.NewFakeClient(), "test")
+	for _, res := range resources {
+		if err := s.AddResource(crds.FromResource(res)); err != nil {
+			t.Fatalf("failed to add resource %s: %v", res.Name, err)
+		}
+	}
+	for _, lc := range lifecycles {
+		if err := s.AddDynamicResourceLifeCycle(crds.FromDynamicResourceLifecycle(lc)); err != nil {
+			t.Fatalf("failed to add dynamic resource life cycle %s: %v", lc.Type, err)
+		}
+	}
+	r, err := ranch.NewRanch("", s, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create ranch: %v", err)
+	}
+	return &localBoskos{storage: s, server: httptest.NewServer(handlers.NewBoskosHandler(r))}
+}
+
+func (b *localBoskos) waitForState(name, state string) (*common.Resource, error) {
+	timeout := time.After(masonTimeout)
+	for {
+		select {
+		case <-timeout:
+			return nil, fmt.Errorf("timed out waiting for resource %s to be %s", name, state)
+		case <-time.After(masonWaitPeriod):
+			crd, err := b.storage.GetResource(name)
+			if err != nil {
+				return nil, err
+			}
+			if res := crd.ToResource(); res.State == state {
+				return &res, nil
+			}
+		}
+	}
+}
+
+func TestMasonConstruct(t *testing.T) {
+	f := NewFakeGCP()
+	f.OperationDuration = 50 * time.Millisecond
+	SetClient(NewFakeClient(f))
+
+	config := `gcp-project:
+- clusters:
+  - machinetype: n1-standard-4
+    numnodes: 1
+  vms:
+  - machinetype: n1-standard-4
+  - machinetype: n1-standard-4
+`
+	project := common.NewResource("project-0", "gcp-project", common.Free, "", time.Now())
+	env := common.NewResource("env-0", "gcp-env", common.Dirty, "", time.Now())
+	env.UserData = &common.UserData{}
+	boskos := newLocalBoskos(t,
+		[]common.Resource{project, env},
+		[]common.DynamicResourceLifeCycle{{
+			Type:     "gcp-env",
+			MinCount: 1,
+			MaxCount: 1,
+			Config:   common.ConfigType{Type: ResourceConfigType, Content: config},
+			Needs:    common.ResourceNeeds{"gcp-project": 1},
+		}},
+	)
+	defer boskos.server.Close()
+
+	boskosClient, err := client.NewClient("mason", boskos.server.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mason.NewMason(1, boskosClient, masonWaitPeriod, masonTimeout, boskos.storage)
+	if err := m.RegisterConfigConverter(ResourceConfigType, ConfigConverter); err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	defer m.Stop()
+
+	res, err := boskos.waitForState("env-0", common.Free)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := boskos.waitForState("project-0", "env-0"); err != nil {
+		t.Fatal(err)
+	}
+
+	var info ResourceInfo
+	if err := res.UserData.Extract(ResourceConfigType, &info); err != nil {
+		t.Fatalf("unable to parse %s user data: %v", ResourceConfigType, err)
+	}
+	var (
+		instances []FakeInstance
+		expected  []FakeInstance
+	)
+	for _, i := range f.Instances() {
+		instances = append(instances, FakeInstance{Kind: i.Kind, Project: i.Project, Zone: i.Zone, Name: i.Name})
+	}
+	for _, c := range info["project-0"].Clusters {
+		expected = append(expected, FakeInstance{Kind: FakeCluster, Project: "project-0", Zone: c.Zone, Name: c.Name})
+	}
+	for _, vm := range info["project-0"].VMs {
+		expected = append(expected, FakeInstance{Kind: FakeVM, Project: "project-0", Zone: vm.Zone, Name: vm.Name})
+	}
+	if len(info) != 1 || len(expected) != 3 {
+		t.Fatalf("expected a cluster and 2 vms in project-0, got %v", info)
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i].key() < expected[j].key() })
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("expected instances %v, got %v", expected, instances)
+	}
+
+	var data string
+	if err := res.UserData.Extract(KubeConfigKey, &data); err != nil {
+		t.Fatalf("unable to parse %s user data: %v", KubeConfigKey, err)
+	}
+	var kubeconfig clientapi.Config
+	if err := yaml.Unmarshal([]byte(data), &kubeconfig); err != nil {
+		t.Fatalf("unable to parse kubeconfig: %v", err)
+	}
+	name := contextName("project-0", info["project-0"].Clusters[0])
+	if names := contextNames(&kubeconfig); kubeconfig.CurrentContext != name || !reflect.DeepEqual(names, []string{name}) {
+		t.Errorf("expected a single context %s, got %v with current context %s", name, names, kubeconfig.CurrentContext)
+	}
+}
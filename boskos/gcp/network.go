@@ -0,0 +1,221 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+)
+
+const globalLocation = "global"
+
+// addressResource reserves static external ip addresses, in the region of their config, or global ones.
+type addressResource struct {
+	service *compute.Service
+}
+
+func isGlobal(location string) bool {
+	return location == "" || location == globalLocation
+}
+
+func (a *addressResource) validate(config kindConfig) error {
+	if !isGlobal(config.Location) && !isRegion(config.Location) {
+		return fmt.Errorf("location of addresses must be a region or global, got %s", config.Location)
+	}
+	if len(config.Labels) > 0 {
+		return fmt.Errorf("addresses do not support labels")
+	}
+	return checkOptions(config)
+}
+
+func (a *addressResource) create(ctx context.Context, project string, config kindConfig) (*InstanceInfo, error) {
+	name := generateName("ip")
+	address := &compute.Address{Name: name}
+	var (
+		op  *compute.Operation
+		err error
+	)
+	location := config.Location
+	if isGlobal(location) {
+		location = globalLocation
+		op, err = a.service.GlobalAddresses.Insert(project, address).Context(ctx).Do()
+	} else {
+		op, err = a.service.Addresses.Insert(project, location, address).Context(ctx).Do()
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("failed to create address %s on project %s", name, project)
+		return nil, err
+	}
+	logrus.Infof("Address %s being created via operation %s, waiting for completion", name, op.Name)
+	info := &InstanceInfo{Name: name, Zone: location}
+	if err := a.waitForOperation(ctx, project, location, op); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+func (a *addressResource) waitForOperation(ctx context.Context, project, location string, op *compute.Operation) error {
+	return waitForComputeOperation(ctx, func() (*compute.Operation, error) {
+		if isGlobal(location) {
+			return a.service.GlobalOperations.Get(project, op.Name).Context(ctx).Do()
+		}
+		return a.service.RegionOperations.Get(project, location, op.Name).Context(ctx).Do()
+	})
+}
+
+func (a *addressResource) delete(ctx context.Context, project string, info InstanceInfo) error {
+	var (
+		op  *compute.Operation
+		err error
+	)
+	if isGlobal(info.Zone) {
+		op, err = a.service.GlobalAddresses.Delete(project, info.Name).Context(ctx).Do()
+	} else {
+		op, err = a.service.Addresses.Delete(project, info.Zone, info.Name).Context(ctx).Do()
+	}
+	if err != nil {
+		return err
+	}
+	return a.waitForOperation(ctx, project, info.Zone, op)
+}
+
+// describe returns the ip of an address.
+func (a *addressResource) describe(ctx context.Context, project string, info InstanceInfo) (string, error) {
+	var (
+		address *compute.Address
+		err     error
+	)
+	if isGlobal(info.Zone) {
+		address, err = a.service.GlobalAddresses.Get(project, info.Name).Context(ctx).Do()
+	} else {
+		address, err = a.service.Addresses.Get(project, info.Zone, info.Name).Context(ctx).Do()
+	}
+	if err != nil {
+		return "", err
+	}
+	return address.Address, nil
+}
+
+// firewallResource creates firewall rules allowing ingress traffic to a network, the default network by default.
+// Its options are:
+//   - network: a name in the project, or a path like projects/p/global/networks/n.
+//   - allow: the traffic allowed, like tcp:22,tcp:8080-8090,icmp.
+//   - sourceranges and targettags: comma separated lists restricting the rule.
+type firewallResource struct {
+	service *compute.Service
+}
+
+// parseAllowed parses the traffic allowed by a firewall rule, grouping the ports by protocol.
+func parseAllowed(allow string) ([]*compute.FirewallAllowed, error) {
+	var allowed []*compute.FirewallAllowed
+	byProtocol := map[string]*compute.FirewallAllowed{}
+	for _, rule := range splitList(allow) {
+		parts := strings.Split(rule, ":")
+		if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+			return nil, fmt.Errorf("invalid rule %q, expected protocol or protocol:ports", rule)
+		}
+		a, ok := byProtocol[parts[0]]
+		if !ok {
+			a = &compute.FirewallAllowed{IPProtocol: parts[0]}
+			byProtocol[parts[0]] = a
+			allowed = append(allowed, a)
+		}
+		if len(parts) == 2 {
+			a.Ports = append(a.Ports, parts[1])
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("firewall rules need traffic to allow")
+	}
+	return allowed, nil
+}
+
+func (fr *firewallResource) validate(config kindConfig) error {
+	if config.Location != "" {
+		return fmt.Errorf("firewall rules are global, got location %s", config.Location)
+	}
+	if len(config.Labels) > 0 {
+		return fmt.Errorf("firewall rules do not support labels")
+	}
+	if err := checkOptions(config, "network", "allow", "sourceranges", "targettags"); err != nil {
+		return err
+	}
+	_, err := parseAllowed(config.Options["allow"])
+	return err
+}
+
+func newFirewall(config kindConfig, project, name string) (*compute.Firewall, error) {
+	allowed, err := parseAllowed(config.Options["allow"])
+	if err != nil {
+		return nil, err
+	}
+	network := config.Options["network"]
+	if network == "" {
+		network = "default"
+	}
+	return &compute.Firewall{
+		Name:         name,
+		Network:      resourcePath(network, "projects/%s/global/networks/%s", project),
+		Allowed:      allowed,
+		SourceRanges: splitList(config.Options["sourceranges"]),
+		TargetTags:   splitList(config.Options["targettags"]),
+	}, nil
+}
+
+func (fr *firewallResource) waitForOperation(ctx context.Context, project string, op *compute.Operation) error {
+	return waitForComputeOperation(ctx, func() (*compute.Operation, error) {
+		return fr.service.GlobalOperations.Get(project, op.Name).Context(ctx).Do()
+	})
+}
+
+func (fr *firewallResource) create(ctx context.Context, project string, config kindConfig) (*InstanceInfo, error) {
+	name := generateName("fw")
+	firewall, err := newFirewall(config, project, name)
+	if err != nil {
+		return nil, err
+	}
+	op, err := fr.service.Firewalls.Insert(project, firewall).Context(ctx).Do()
+	if err != nil {
+		logrus.WithError(err).Errorf("failed to create firewall rule %s on project %s", name, project)
+		return nil, err
+	}
+	logrus.Infof("Firewall rule %s being created via operation %s, waiting for completion", name, op.Name)
+	info := &InstanceInfo{Name: name}
+	if err := fr.waitForOperation(ctx, project, op); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+func (fr *firewallResource) delete(ctx context.Context, project string, info InstanceInfo) error {
+	op, err := fr.service.Firewalls.Delete(project, info.Name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return fr.waitForOperation(ctx, project, op)
+}
+
+// describe returns the network of a firewall rule.
+func (fr *firewallResource) describe(ctx context.Context, project string, info InstanceInfo) (string, error) {
+	firewall, err := fr.service.Firewalls.Get(project, info.Name).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return firewall.Network, nil
+}
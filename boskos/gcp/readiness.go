@@ -0,0 +1,203 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	container "google.golang.org/api/container/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	defaultReadyTimeout = 5 * time.Minute
+	systemNamespace     = "kube-system"
+)
+
+// readinessConfig configures the checks of a cluster once it is created.
+type readinessConfig struct {
+	// Timeout bounds the time waited for the cluster to be ready, 5 minutes by default.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// SystemPods are prefixes of names of pods in kube-system, which must all be ready.
+	SystemPods []string `json:"systempods,omitempty"`
+}
+
+func (r *readinessConfig) timeout() time.Duration {
+	if r == nil || r.Timeout == 0 {
+		return defaultReadyTimeout
+	}
+	return r.Timeout
+}
+
+func (r *readinessConfig) systemPods() []string {
+	if r == nil {
+		return nil
+	}
+	return r.SystemPods
+}
+
+func (r *readinessConfig) validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("invalid readiness timeout %v", r.Timeout)
+	}
+	for _, p := range r.SystemPods {
+		if p == "" {
+			return fmt.Errorf("required system pods must not be empty")
+		}
+	}
+	return nil
+}
+
+// newRESTConfig creates the configuration of a client of a cluster described by the GKE API. Clusters issuing client
+// certificates are accessed with them, and other clusters with tokens of the token source.
+func newRESTConfig(cluster *container.Cluster, tokenSource oauth2.TokenSource) (*rest.Config, error) {
+	ca, cert, key, err := clusterCertificates(cluster)
+	if err != nil {
+		return nil, err
+	}
+	config := &rest.Config{
+		Host:            "https://" + cluster.Endpoint,
+		TLSClientConfig: rest.TLSClientConfig{CAData: ca, CertData: cert, KeyData: key},
+	}
+	if cert != nil {
+		return config, nil
+	}
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return &oauth2.Transport{Source: tokenSource, Base: rt}
+	}
+	return config, nil
+}
+
+// expectedNodes is the number of nodes a cluster has once created, in all its zones. Autoscaled node pools may have
+// as few nodes as their minimum.
+func expectedNodes(cluster *container.Cluster) int64 {
+	var perZone int64
+	for _, np := range cluster.NodePools {
+		if np.Autoscaling != nil && np.Autoscaling.Enabled && np.Autoscaling.MinNodeCount < np.InitialNodeCount {
+			perZone += np.Autoscaling.MinNodeCount
+		} else {
+			perZone += np.InitialNodeCount
+		}
+	}
+	if len(cluster.NodePools) == 0 {
+		perZone = cluster.InitialNodeCount
+	}
+	zones := int64(len(cluster.Locations))
+	if zones == 0 {
+		zones = 1
+	}
+	return perZone * zones
+}
+
+func isNodeReady(node corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func isPodReady(pod corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// checkClusterReady checks that the API server of a cluster is reachable, that at least the expected number of nodes
+// are ready, and that the pods of kube-system named with each required prefix exist and are all ready.
+func checkClusterReady(client kubernetes.Interface, nodes int64, systemPods []string) error {
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		return fmt.Errorf("api server is not reachable: %v", err)
+	}
+
+	nodeList, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list nodes: %v", err)
+	}
+	var ready int64
+	for _, n := range nodeList.Items {
+		if isNodeReady(n) {
+			ready++
+		}
+	}
+	if ready < nodes {
+		return fmt.Errorf("%d of %d nodes are ready", ready, nodes)
+	}
+
+	if len(systemPods) == 0 {
+		return nil
+	}
+	podList, err := client.CoreV1().Pods(systemNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list pods of %s: %v", systemNamespace, err)
+	}
+	for _, prefix := range systemPods {
+		var found bool
+		for _, p := range podList.Items {
+			// Pods of completed jobs are never ready again.
+			if !strings.HasPrefix(p.Name, prefix) || p.Status.Phase == corev1.PodSucceeded {
+				continue
+			}
+			found = true
+			if !isPodReady(p) {
+				return fmt.Errorf("pod %s/%s is not ready", systemNamespace, p.Name)
+			}
+		}
+		if !found {
+			return fmt.Errorf("no pod %s/%s* found", systemNamespace, prefix)
+		}
+	}
+	return nil
+}
+
+// pollReady runs a readiness check until it succeeds, returning the outcome of the last check if the context is done
+// first.
+func pollReady(ctx context.Context, what string, interval time.Duration, check func() error) error {
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		logrus.WithError(err).Infof("%s is not ready", what)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %v", ctx.Err(), err)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// waitForClusterReady checks a cluster until it is ready.
+func waitForClusterReady(ctx context.Context, client kubernetes.Interface, nodes int64, systemPods []string, interval time.Duration) error {
+	return pollReady(ctx, "cluster", interval, func() error {
+		return checkClusterReady(client, nodes, systemPods)
+	})
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	container "google.golang.org/api/container/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newNode(name string, ready bool) *corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}},
+	}
+}
+
+func newPod(name string, phase corev1.PodPhase, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: systemNamespace},
+		Status: corev1.PodStatus{
+			Phase:      phase,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestCheckClusterReady(t *testing.T) {
+	var testCases = []struct {
+		name       string
+		objects    []runtime.Object
+		nodes      int64
+		systemPods []string
+		err        string
+	}{
+		{
+			name:    "nodes ready",
+			objects: []runtime.Object{newNode("n1", true), newNode("n2", true)},
+			nodes:   2,
+		},
+		{
+			name:    "nodes not ready",
+			objects: []runtime.Object{newNode("n1", true), newNode("n2", false)},
+			nodes:   2,
+			err:     "1 of 2 nodes are ready",
+		},
+		{
+			name:    "nodes missing",
+			objects: []runtime.Object{newNode("n1", true)},
+			nodes:   3,
+			err:     "1 of 3 nodes are ready",
+		},
+		{
+			name: "system pods ready",
+			objects: []runtime.Object{
+				newNode("n1", true),
+				newPod("kube-dns-1", corev1.PodRunning, true),
+				newPod("kube-dns-2", corev1.PodRunning, true),
+				newPod("metrics-server-1", corev1.PodRunning, true),
+				newPod("kube-dns-autoscaler-job", corev1.PodSucceeded, false),
+			},
+			nodes:      1,
+			systemPods: []string{"kube-dns", "metrics-server"},
+		},
+		{
+			name: "system pod not ready",
+			objects: []runtime.Object{
+				newNode("n1", true),
+				newPod("kube-dns-1", corev1.PodRunning, true),
+				newPod("kube-dns-2", corev1.PodPending, false),
+			},
+			nodes:      1,
+			systemPods: []string{"kube-dns"},
+			err:        "pod kube-system/kube-dns-2 is not ready",
+		},
+		{
+			name:       "system pod missing",
+			objects:    []runtime.Object{newNode("n1", true), newPod("kube-dns-1", corev1.PodRunning, true)},
+			nodes:      1,
+			systemPods: []string{"kube-dns", "metrics-server"},
+			err:        "no pod kube-system/metrics-server* found",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkClusterReady(fake.NewSimpleClientset(tc.objects...), tc.nodes, tc.systemPods)
+			var errString string
+			if err != nil {
+				errString = err.Error()
+			}
+			if errString != tc.err {
+				t.Errorf("expected error %q, got %q", tc.err, errString)
+			}
+		})
+	}
+}
+
+func TestWaitForClusterReady(t *testing.T) {
+	client := fake.NewSimpleClientset(newNode("n1", false))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := client.CoreV1().Nodes().Update(newNode("n1", true)); err != nil {
+			t.Error(err)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), masonTimeout)
+	defer cancel()
+	if err := waitForClusterReady(ctx, client, 1, nil, 10*time.Millisecond); err != nil {
+		t.Errorf("expected cluster to be ready, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := waitForClusterReady(ctx, client, 2, nil, 10*time.Millisecond)
+	if expected := "context deadline exceeded: 1 of 2 nodes are ready"; err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestExpectedNodes(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		cluster  *container.Cluster
+		expected int64
+	}{
+		{
+			name:     "default node pool",
+			cluster:  &container.Cluster{InitialNodeCount: 3},
+			expected: 3,
+		},
+		{
+			name: "node pools",
+			cluster: &container.Cluster{NodePools: []*container.NodePool{
+				{InitialNodeCount: 1},
+				{InitialNodeCount: 4, Autoscaling: &container.NodePoolAutoscaling{Enabled: true, MinNodeCount: 2, MaxNodeCount: 10}},
+				{InitialNodeCount: 1, Autoscaling: &container.NodePoolAutoscaling{Enabled: true, MinNodeCount: 2, MaxNodeCount: 10}},
+			}},
+			expected: 4,
+		},
+		{
+			name: "regional",
+			cluster: &container.Cluster{
+				Locations: []string{"us-central1-a", "us-central1-b", "us-central1-c"},
+				NodePools: []*container.NodePool{{InitialNodeCount: 2}},
+			},
+			expected: 6,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if nodes := expectedNodes(tc.cluster); nodes != tc.expected {
+				t.Errorf("expected %d nodes, got %d", tc.expected, nodes)
+			}
+		})
+	}
+}
+
+func TestNewRESTConfig(t *testing.T) {
+	var available = true
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer "+FakeToken:
+			w.WriteHeader(http.StatusUnauthorized)
+		case !available:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"major": "1", "minor": "15"}`))
+		}
+	}))
+	defer server.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	cluster := &container.Cluster{
+		Name:       "c",
+		Endpoint:   strings.TrimPrefix(server.URL, "https://"),
+		MasterAuth: &container.MasterAuth{ClusterCaCertificate: encode(string(ca))},
+	}
+	config, err := newRESTConfig(cluster, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: FakeToken}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := client.Discovery().ServerVersion(); err != nil || v.Minor != "15" {
+		t.Errorf("expected server version 1.15, got %v and %v", v, err)
+	}
+
+	available = false
+	if err := checkClusterReady(client, 0, nil); err == nil || !strings.HasPrefix(err.Error(), "api server is not reachable") {
+		t.Errorf("expected api server not to be reachable, got %v", err)
+	}
+
+	if _, err := newRESTConfig(&container.Cluster{Name: "c"}, nil); err == nil {
+		t.Error("expected cluster without certificate authority to fail")
+	}
+}
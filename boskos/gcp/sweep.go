@@ -0,0 +1,153 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/test-infra/boskos/common"
+)
+
+// heldInstances records the instances held by boskos resources, and the names of the resources as labels. Several
+// resource names may have the same label, so instances are held whichever resource records them.
+type heldInstances struct {
+	instances map[string]bool
+	resources map[string]bool
+}
+
+func heldKey(kind, project string, info InstanceInfo) string {
+	return fmt.Sprintf("%s/%s/%s/%s", kind, project, info.Zone, info.Name)
+}
+
+func newHeldInstances(resources []common.Resource) heldInstances {
+	held := heldInstances{instances: map[string]bool{}, resources: map[string]bool{}}
+	for _, res := range resources {
+		held.resources[labelValue(res.Name)] = true
+		if res.UserData == nil {
+			continue
+		}
+		var info ResourceInfo
+		if err := res.UserData.Extract(ResourceConfigType, &info); err != nil {
+			if _, ok := err.(*common.UserDataNotFound); !ok {
+				logrus.WithError(err).Warningf("unable to parse %s user data of %s", ResourceConfigType, res.Name)
+			}
+			continue
+		}
+		for project, pi := range info {
+			for _, c := range pi.Clusters {
+				held.instances[heldKey("cluster", project, c)] = true
+			}
+			for _, vm := range pi.VMs {
+				held.instances[heldKey("vm", project, vm)] = true
+			}
+		}
+	}
+	return held
+}
+
+// Sweep deletes the clusters and vms of projects that are labeled with a boskos resource no longer holding them, once
+// they are older than the grace period. Instances are held by a resource while they are recorded in its user data,
+// i.e. from the end of their construction until the resource is cleaned up, such that instances of resources that no
+// longer exist, and instances that failed to be deleted, are swept. Whether the resource is leased does not matter,
+// as mason constructs resources before they are leased. Resources of other kinds, such as buckets, are not swept. With
+// dryRun, the instances are only logged.
+func (c *Client) Sweep(ctx context.Context, projects []string, resources []common.Resource, grace time.Duration, dryRun bool) error {
+	held := newHeldInstances(resources)
+	now := time.Now()
+
+	var errs []error
+	leaked := map[string]ResourceInfo{}
+	sweep := func(kind, project string, list func(context.Context, string) ([]labeledInstance, error)) {
+		instances, err := list(ctx, project)
+		if err != nil {
+			logrus.WithError(err).Errorf("unable to list %ss of project %s", kind, project)
+			errs = append(errs, fmt.Errorf("%ss of project %s: %v", kind, project, err))
+			return
+		}
+		for _, i := range instances {
+			resource := i.Labels[resourceLabel]
+			log := logrus.WithFields(logrus.Fields{"project": project, "zone": i.Zone, "resource": resource})
+			created, ok := i.created()
+			if !ok {
+				log.Warningf("%s %s has no valid %s label, skipping it", kind, i.Name, createdLabel)
+				continue
+			}
+			if now.Sub(created) < grace {
+				continue
+			}
+			if held.instances[heldKey(kind, project, i.InstanceInfo)] {
+				continue
+			}
+			if held.resources[resource] {
+				log.Infof("%s %s is no longer held by its resource", kind, i.Name)
+			} else {
+				log.Infof("%s %s belongs to a resource that no longer exists", kind, i.Name)
+			}
+			info, ok := leaked[resource]
+			if !ok {
+				info = ResourceInfo{}
+				leaked[resource] = info
+			}
+			pi := info[project]
+			if kind == "cluster" {
+				pi.Clusters = append(pi.Clusters, i.InstanceInfo)
+			} else {
+				pi.VMs = append(pi.VMs, i.InstanceInfo)
+			}
+			info[project] = pi
+		}
+	}
+	for _, project := range projects {
+		sweep("cluster", project, c.gke.list)
+		sweep("vm", project, c.gce.list)
+	}
+
+	var names []string
+	for name := range leaked {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if dryRun {
+		logrus.Infof("Dry run, not deleting the instances of resources %v", names)
+	} else {
+		var (
+			wg sync.WaitGroup
+			mu sync.Mutex
+		)
+		for _, name := range names {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				if err := c.cleanup(ctx, name, leaked[name]); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}(name)
+		}
+		wg.Wait()
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to sweep projects %v: %v", projects, utilerrors.NewAggregate(errs))
+	}
+	return nil
+}
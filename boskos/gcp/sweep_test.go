@@ -0,0 +1,183 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/test-infra/boskos/common"
+)
+
+func TestLabelValue(t *testing.T) {
+	var testCases = []struct {
+		value    string
+		expected string
+	}{
+		{value: "gke-e2e-test-0", expected: "gke-e2e-test-0"},
+		{value: "Istio_Boskos.01", expected: "istio_boskos-01"},
+		{value: strings.Repeat("a", 70), expected: strings.Repeat("a", maxLabelLength)},
+	}
+	for _, tc := range testCases {
+		if value := labelValue(tc.value); value != tc.expected {
+			t.Errorf("expected label value %s for %s, got %s", tc.expected, tc.value, value)
+		}
+	}
+}
+
+func TestConstructLabels(t *testing.T) {
+	f := NewFakeGCP()
+	SetClient(NewFakeClient(f))
+	rc := resourceConfigs{
+		"project": {{
+			Clusters: []clusterConfig{{Labels: map[string]string{"team": "perf"}}},
+			// The labels of boskos take precedence over the ones of the config.
+			Vms: []virtualMachineConfig{{Labels: map[string]string{ownerLabel: "user"}}},
+		}},
+	}
+	res := common.Resource{Name: "Env-0", Type: "gcp-env", Owner: "mason"}
+	before := time.Now().Unix()
+	if _, _, err := rc.construct(context.Background(), res, common.TypeToResources{"project": {{Name: "p"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range f.Instances() {
+		created, err := strconv.ParseInt(i.Labels[createdLabel], 10, 64)
+		if err != nil || created < before || created > time.Now().Unix() {
+			t.Errorf("unexpected creation time label %s of %s %s", i.Labels[createdLabel], i.Kind, i.Name)
+		}
+		expected := map[string]string{
+			resourceLabel: "env-0",
+			typeLabel:     "gcp-env",
+			ownerLabel:    "mason",
+			createdLabel:  i.Labels[createdLabel],
+		}
+		if i.Kind == FakeCluster {
+			expected["team"] = "perf"
+		}
+		if !reflect.DeepEqual(i.Labels, expected) {
+			t.Errorf("expected labels %v for %s %s, got %v", expected, i.Kind, i.Name, i.Labels)
+		}
+	}
+	if labels := rc["project"][0].Clusters[0].Labels; len(labels) != 1 {
+		t.Errorf("expected the config not to be modified, got labels %v", labels)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	f := NewFakeGCP()
+	client := NewFakeClient(f)
+	SetClient(client)
+	ctx := context.Background()
+	types := func(project string) common.TypeToResources {
+		return common.TypeToResources{"project": {{Name: project}}}
+	}
+
+	// Instances held by a resource are kept.
+	held := common.Resource{Name: "held", Type: "gcp-env", Owner: "mason"}
+	rc := resourceConfigs{"project": {{Clusters: []clusterConfig{{}}, Vms: []virtualMachineConfig{{}}}}}
+	userData, _, err := rc.construct(ctx, held, types("p1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	held.UserData = userData
+
+	createVM := func(project, resource string, age time.Duration) string {
+		t.Helper()
+		labels := resourceLabels(common.Resource{Name: resource}, time.Now().Add(-age))
+		info, err := client.gce.create(ctx, project, virtualMachineConfig{Zone: "us-central1-a", Labels: labels})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Name
+	}
+	hold := func(res *common.Resource, project, name string) {
+		t.Helper()
+		info := ResourceInfo{}
+		if res.UserData == nil {
+			res.UserData = &common.UserData{}
+		} else if err := res.UserData.Extract(ResourceConfigType, &info); err != nil {
+			t.Fatal(err)
+		}
+		pi := info[project]
+		pi.VMs = append(pi.VMs, InstanceInfo{Name: name, Zone: "us-central1-a"})
+		info[project] = pi
+		if err := res.UserData.Set(ResourceConfigType, &info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Resources whose names have the same label hold their own instances.
+	sameLabel := common.Resource{Name: "Held", Type: "gcp-env", Owner: "mason"}
+	hold(&held, "p1", createVM("p1", held.Name, 2*time.Hour))
+	hold(&sameLabel, "p1", createVM("p1", sameLabel.Name, 2*time.Hour))
+	resources := []common.Resource{held, sameLabel}
+
+	// Instances are swept once older than the grace period, whether their resource exists or not.
+	leakedByHeld := createVM("p1", "held", 2*time.Hour)
+	leakedByGone := createVM("p2", "gone", 2*time.Hour)
+	createVM("p2", "gone", time.Minute)
+	// Instances without labels are ignored.
+	if _, err := client.gce.create(ctx, "p2", virtualMachineConfig{Zone: "us-central1-b"}); err != nil {
+		t.Fatal(err)
+	}
+	// Instances of projects that are not swept are ignored.
+	createVM("p3", "gone", 2*time.Hour)
+
+	names := func() []string {
+		var names []string
+		for _, i := range f.Instances() {
+			names = append(names, i.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	all := names()
+	if len(all) != 9 {
+		t.Fatalf("expected 9 instances, got %v", all)
+	}
+
+	if err := client.Sweep(ctx, []string{"p1", "p2"}, resources, time.Hour, true); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := names(); !reflect.DeepEqual(remaining, all) {
+		t.Errorf("expected dry run to delete nothing, got %v", remaining)
+	}
+
+	if err := client.Sweep(ctx, []string{"p1", "p2"}, resources, time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+	var expected []string
+	for _, name := range all {
+		if name != leakedByHeld && name != leakedByGone {
+			expected = append(expected, name)
+		}
+	}
+	if remaining := names(); !reflect.DeepEqual(remaining, expected) {
+		t.Errorf("expected instances %v, got %v", expected, remaining)
+	}
+
+	f.Fail(FakeFailure{Method: FakeList, Kind: FakeCluster, Project: "p3"})
+	if err := client.Sweep(ctx, []string{"p3"}, nil, time.Hour, false); err == nil {
+		t.Error("expected sweep to fail")
+	}
+	if instances := f.Instances(); len(instances) != len(expected)-1 {
+		t.Errorf("expected the vms of the project to be swept despite the failure, got %v", instances)
+	}
+}
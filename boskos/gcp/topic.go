@@ -0,0 +1,62 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// topicResource creates Pub/Sub topics. Their subscriptions are not deleted with them.
+type topicResource struct {
+	service *pubsub.Service
+}
+
+func topicPath(project, name string) string {
+	return fmt.Sprintf("projects/%s/topics/%s", project, name)
+}
+
+func (t *topicResource) validate(config kindConfig) error {
+	if config.Location != "" {
+		return fmt.Errorf("topics are global, got location %s", config.Location)
+	}
+	return checkOptions(config)
+}
+
+func (t *topicResource) create(ctx context.Context, project string, config kindConfig) (*InstanceInfo, error) {
+	name := generateName("topic")
+	if _, err := t.service.Projects.Topics.Create(topicPath(project, name), &pubsub.Topic{Labels: config.Labels}).Context(ctx).Do(); err != nil {
+		logrus.WithError(err).Errorf("failed to create topic %s on project %s", name, project)
+		return nil, err
+	}
+	logrus.Infof("Topic %s created on project %s", name, project)
+	return &InstanceInfo{Name: name}, nil
+}
+
+func (t *topicResource) delete(ctx context.Context, project string, info InstanceInfo) error {
+	_, err := t.service.Projects.Topics.Delete(topicPath(project, info.Name)).Context(ctx).Do()
+	return err
+}
+
+func (t *topicResource) describe(ctx context.Context, project string, info InstanceInfo) (string, error) {
+	topic, err := t.service.Projects.Topics.Get(topicPath(project, info.Name)).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return topic.Name, nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	zoneUSPrefix       = "us-"
+	quotaExceededError = "quotaExceeded"
+	// quotaSkipDuration is how long a location in which a quota was exceeded is skipped, as quotas may be freed by
+	// other resources being deleted.
+	quotaSkipDuration = 30 * time.Minute
+)
+
+// zonePolicy restricts the zones picked for the instances of a project that do not set a zone.
+type zonePolicy struct {
+	// Regions are the regions allowed. When empty, zones starting with us- are allowed.
+	Regions []string `json:"regions,omitempty"`
+	// Exclude lists zones and regions that are not allowed.
+	Exclude []string `json:"exclude,omitempty"`
+	// Spread places every instance in a distinct zone, failing when there are not enough zones.
+	Spread bool `json:"spread"`
+}
+
+// regionOf returns the region of a zone, or the location itself if it is a region.
+func regionOf(location string) string {
+	if isRegion(location) {
+		return location
+	}
+	return location[:strings.LastIndex(location, "-")]
+}
+
+// isRegion checks if a location is a region, like us-central1, rather than a zone, like us-central1-a.
+func isRegion(location string) bool {
+	return strings.Count(location, "-") < 2
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the zones allowed by the policy, keeping their order.
+func (p *zonePolicy) filter(zones []string) []string {
+	if p == nil {
+		p = &zonePolicy{}
+	}
+	var allowed []string
+	for _, z := range zones {
+		region := regionOf(z)
+		if len(p.Regions) == 0 && !strings.HasPrefix(z, zoneUSPrefix) {
+			continue
+		}
+		if len(p.Regions) > 0 && !contains(p.Regions, region) {
+			continue
+		}
+		if contains(p.Exclude, z) || contains(p.Exclude, region) {
+			continue
+		}
+		allowed = append(allowed, z)
+	}
+	return allowed
+}
+
+// isQuotaError checks if an error reports that a quota is exceeded. Errors of operations only carry a message.
+func isQuotaError(err error) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		for _, item := range e.Errors {
+			if item.Reason == quotaExceededError {
+				return true
+			}
+		}
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "quota")
+}
+
+// quotaTracker records the locations of each project in which the last creation failed because a quota was
+// exceeded, and when. It is safe for concurrent use.
+type quotaTracker struct {
+	mu       sync.Mutex
+	exceeded map[string]time.Time
+	// now returns the current time, defaulting to time.Now.
+	now func() time.Time
+}
+
+func quotaKey(project, location string) string {
+	return project + "/" + location
+}
+
+// record updates the outcome of the last creation in a location.
+func (t *quotaTracker) record(project, location string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.exceeded == nil {
+		t.exceeded = map[string]time.Time{}
+	}
+	key := quotaKey(project, location)
+	switch {
+	case err == nil:
+		delete(t.exceeded, key)
+	case isQuotaError(err):
+		logrus.Warningf("quota exceeded in %s for project %s, skipping it for %v or until a creation succeeds", location, project, quotaSkipDuration)
+		t.exceeded[key] = t.clock()
+	}
+}
+
+func (t *quotaTracker) clock() time.Time {
+	if t.now == nil {
+		return time.Now()
+	}
+	return t.now()
+}
+
+// skipped checks if a quota was exceeded in a location recently. Must be called with the lock held.
+func (t *quotaTracker) skipped(project, location string) bool {
+	at, ok := t.exceeded[quotaKey(project, location)]
+	return ok && t.clock().Sub(at) < quotaSkipDuration
+}
+
+// available removes the zones in which the last creation recently exceeded a quota, for the zone or its region. When
+// fewer than needed zones remain, all zones are returned, as quotas may have been freed since.
+func (t *quotaTracker) available(project string, zones []string, needed int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var available []string
+	for _, z := range zones {
+		if t.skipped(project, z) || t.skipped(project, regionOf(z)) {
+			continue
+		}
+		available = append(available, z)
+	}
+	if len(available) == 0 || len(available) < needed {
+		return zones
+	}
+	return available
+}
+
+// pickZones returns the zones in which instances not setting their zone are placed for a project.
+func (c *Client) pickZones(project string, policy *zonePolicy, count int) (*stringRing, error) {
+	zones, err := c.gce.listZones(project)
+	if err != nil {
+		return nil, err
+	}
+	zones = policy.filter(zones)
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("no zone of project %s is allowed", project)
+	}
+	// Spread instances need as many zones as instances, others a single one.
+	spread := policy != nil && policy.Spread
+	needed := 1
+	if spread {
+		needed = count
+	}
+	zones = c.quota.available(project, zones, needed)
+	if spread && len(zones) < count {
+		return nil, fmt.Errorf("not enough zones to spread %d instances in project %s: %v", count, project, zones)
+	}
+	logrus.Infof("Using zones %v for project %s", zones, project)
+	return newStringRing(zones), nil
+}
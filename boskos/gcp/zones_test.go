@@ -0,0 +1,252 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"k8s.io/test-infra/boskos/common"
+)
+
+func TestRegionOf(t *testing.T) {
+	for location, expected := range map[string]string{
+		"us-central1-a":  "us-central1",
+		"us-central1":    "us-central1",
+		"europe-west4-c": "europe-west4",
+	} {
+		if region := regionOf(location); region != expected {
+			t.Errorf("expected region %s of %s, got %s", expected, location, region)
+		}
+	}
+}
+
+func TestZonePolicyFilter(t *testing.T) {
+	zones := []string{"us-central1-a", "us-central1-b", "us-east1-b", "europe-west4-a", "asia-east1-a"}
+	var testCases = []struct {
+		name     string
+		policy   *zonePolicy
+		expected []string
+	}{
+		{
+			name:     "default",
+			expected: []string{"us-central1-a", "us-central1-b", "us-east1-b"},
+		},
+		{
+			name:     "regions",
+			policy:   &zonePolicy{Regions: []string{"us-central1", "europe-west4"}},
+			expected: []string{"us-central1-a", "us-central1-b", "europe-west4-a"},
+		},
+		{
+			name:     "excluded zone",
+			policy:   &zonePolicy{Exclude: []string{"us-central1-b"}},
+			expected: []string{"us-central1-a", "us-east1-b"},
+		},
+		{
+			name:     "excluded region",
+			policy:   &zonePolicy{Regions: []string{"us-central1", "asia-east1"}, Exclude: []string{"us-central1"}},
+			expected: []string{"asia-east1-a"},
+		},
+		{
+			name:   "nothing allowed",
+			policy: &zonePolicy{Regions: []string{"australia-southeast1"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if allowed := tc.policy.filter(zones); !reflect.DeepEqual(allowed, tc.expected) {
+				t.Errorf("expected zones %v, got %v", tc.expected, allowed)
+			}
+		})
+	}
+}
+
+func TestIsQuotaError(t *testing.T) {
+	var testCases = []struct {
+		err      error
+		expected bool
+	}{
+		{
+			err:      &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: quotaExceededError}}},
+			expected: true,
+		},
+		{
+			err:      fmt.Errorf("Insufficient regional quota to satisfy request: resource \"CPUS\""),
+			expected: true,
+		},
+		{
+			err: &googleapi.Error{Code: http.StatusNotFound, Message: "not found"},
+		},
+		{
+			err: fmt.Errorf("fail"),
+		},
+	}
+
+	for _, tc := range testCases {
+		if isQuotaError(tc.err) != tc.expected {
+			t.Errorf("expected quota error %t for %v", tc.expected, tc.err)
+		}
+	}
+}
+
+func TestQuotaTracker(t *testing.T) {
+	zones := []string{"us-central1-a", "us-central1-b", "us-east1-b"}
+	quota := fmt.Errorf("quota exceeded")
+	now := time.Now()
+	tracker := quotaTracker{now: func() time.Time { return now }}
+
+	tracker.record("p", "us-central1-a", quota)
+	tracker.record("p", "us-east1-b", fmt.Errorf("fail"))
+	if available := tracker.available("p", zones, 1); !reflect.DeepEqual(available, []string{"us-central1-b", "us-east1-b"}) {
+		t.Errorf("expected zone exceeding quota to be skipped, got %v", available)
+	}
+	if available := tracker.available("other", zones, 1); !reflect.DeepEqual(available, zones) {
+		t.Errorf("expected zones of another project to be available, got %v", available)
+	}
+	if available := tracker.available("p", zones, 3); !reflect.DeepEqual(available, zones) {
+		t.Errorf("expected all zones when fewer than needed remain, got %v", available)
+	}
+
+	// A regional cluster exceeding quotas skips the zones of its region.
+	tracker.record("p", "us-central1-a", nil)
+	tracker.record("p", "us-central1", quota)
+	if available := tracker.available("p", zones, 1); !reflect.DeepEqual(available, []string{"us-east1-b"}) {
+		t.Errorf("expected zones of region exceeding quota to be skipped, got %v", available)
+	}
+
+	tracker.record("p", "us-east1-b", quota)
+	if available := tracker.available("p", zones, 1); !reflect.DeepEqual(available, zones) {
+		t.Errorf("expected all zones when all exceed quota, got %v", available)
+	}
+
+	// Zones are skipped for a while only.
+	now = now.Add(quotaSkipDuration)
+	tracker.record("p", "us-east1-b", quota)
+	if available := tracker.available("p", zones, 1); !reflect.DeepEqual(available, []string{"us-central1-a", "us-central1-b"}) {
+		t.Errorf("expected zones exceeding quota long ago to be available, got %v", available)
+	}
+}
+
+func TestConstructZonePolicy(t *testing.T) {
+	types := func() common.TypeToResources {
+		return common.TypeToResources{"project": {{Name: "p"}}}
+	}
+	zonesOf := func(info *ResourceInfo) []string {
+		var zones []string
+		for _, cl := range (*info)["p"].Clusters {
+			zones = append(zones, cl.Zone)
+		}
+		for _, vm := range (*info)["p"].VMs {
+			zones = append(zones, vm.Zone)
+		}
+		sort.Strings(zones)
+		return zones
+	}
+
+	t.Run("regions and regional clusters", func(t *testing.T) {
+		f := NewFakeGCP()
+		f.Zones = []string{"us-central1-a", "us-east1-b", "us-east1-c"}
+		SetClient(NewFakeClient(f))
+		rc := resourceConfigs{"project": {{
+			Clusters: []clusterConfig{{Regional: true}, {Zone: "us-central1-a"}},
+			Vms:      []virtualMachineConfig{{}},
+			Zones:    &zonePolicy{Regions: []string{"us-east1"}},
+		}}}
+		_, info, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []string{"us-central1-a", "us-east1", "us-east1-c"}; !reflect.DeepEqual(zonesOf(info), expected) {
+			t.Errorf("expected zones %v, got %v", expected, zonesOf(info))
+		}
+	})
+
+	t.Run("not enough zones to spread", func(t *testing.T) {
+		f := NewFakeGCP()
+		f.Zones = []string{"us-central1-a", "us-east1-b"}
+		SetClient(NewFakeClient(f))
+		rc := resourceConfigs{"project": {{
+			Vms:   []virtualMachineConfig{{}, {}, {}},
+			Zones: &zonePolicy{Spread: true},
+		}}}
+		_, _, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types())
+		expected := "not enough zones to spread 3 instances in project p: [us-central1-a us-east1-b]"
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected error %q, got %v", expected, err)
+		}
+	})
+
+	t.Run("zones exceeding quota are skipped", func(t *testing.T) {
+		f := NewFakeGCP()
+		f.Zones = []string{"us-central1-a", "us-central1-b"}
+		f.Fail(FakeFailure{
+			Method: FakeCreate,
+			Zone:   "us-central1-a",
+			Times:  1,
+			Err:    &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: quotaExceededError}}},
+		})
+		SetClient(NewFakeClient(f))
+		rc := resourceConfigs{"project": {{Vms: []virtualMachineConfig{{}}}}}
+
+		if _, _, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types()); err == nil {
+			t.Fatal("expected construct to fail")
+		}
+		for i := 0; i < 2; i++ {
+			_, info, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := []string{"us-central1-b"}; !reflect.DeepEqual(zonesOf(info), expected) {
+				t.Errorf("expected zones %v, got %v", expected, zonesOf(info))
+			}
+		}
+	})
+
+	t.Run("zones exceeding quota are used to spread", func(t *testing.T) {
+		f := NewFakeGCP()
+		f.Zones = []string{"us-central1-a", "us-central1-b"}
+		f.Fail(FakeFailure{
+			Method: FakeCreate,
+			Zone:   "us-central1-a",
+			Times:  1,
+			Err:    &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: quotaExceededError}}},
+		})
+		SetClient(NewFakeClient(f))
+		rc := resourceConfigs{"project": {{
+			Vms:   []virtualMachineConfig{{}, {}},
+			Zones: &zonePolicy{Spread: true},
+		}}}
+
+		if _, _, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types()); err == nil {
+			t.Fatal("expected construct to fail")
+		}
+		// Skipping the zone exceeding quota would leave too few zones to spread the vms.
+		_, info, err := rc.construct(context.Background(), common.Resource{Name: "test"}, types())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []string{"us-central1-a", "us-central1-b"}; !reflect.DeepEqual(zonesOf(info), expected) {
+			t.Errorf("expected zones %v, got %v", expected, zonesOf(info))
+		}
+	})
+}
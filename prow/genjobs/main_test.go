@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -33,8 +34,22 @@ import (
 
 const (
 	testDir = "testdata"
+	// argsEnv holds the newline separated arguments genjobs is run with in a subprocess of the tests.
+	argsEnv = "GENJOBS_TEST_ARGS"
 )
 
+// TestMain runs genjobs rather than the tests when arguments are set in the environment, so that tests can assert
+// the exit status and output of genjobs in a subprocess.
+func TestMain(m *testing.M) {
+	if args := os.Getenv(argsEnv); args != "" {
+		os.Args = append([]string{"genjobs"}, strings.Split(args, "\n")...)
+		genjobs.Main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
 func resolvePath(t *testing.T, filename string) string {
 	name := strings.ToLower(filepath.Base(t.Name()))
 	return filepath.Join(testDir, strings.ToLower(name), name+filename)
@@ -74,11 +89,13 @@ func parseConfigTmpl(input, output, config, dir string) (string, error) {
 
 func TestGenjobs(t *testing.T) {
 	tests := []struct {
-		name    string
-		output  string
-		args    []string
-		configs bool
-		equal   bool
+		name     string
+		output   string
+		args     []string
+		configs  bool
+		existing bool
+		runs     int
+		equal    bool
 	}{
 		{
 			name:  "simple transform",
@@ -95,6 +112,11 @@ func TestGenjobs(t *testing.T) {
 			args:  []string{"--mapping=istio=istio-private", "--refs"},
 			equal: true,
 		},
+		{
+			name:  "branch mapping",
+			args:  []string{"--mapping=istio=istio-private", "--branch-mapping=master=private-master,^release-(.*)$=private-release-$1"},
+			equal: true,
+		},
 		{
 			name:  "refs not exists",
 			args:  []string{"--mapping=istio=istio-private", "--refs"},
@@ -135,6 +157,77 @@ func TestGenjobs(t *testing.T) {
 			args:  []string{"--mapping=istio=istio-private", "--volume-blacklist=bad-volume"},
 			equal: true,
 		},
+		{
+			name:  "job selectors",
+			args:  []string{"--mapping=istio=istio-private", "--include=preset:service-account,always_run", "--exclude=cluster=private", "--exclude=optional"},
+			equal: true,
+		},
+		{
+			name:  "job patterns",
+			args:  []string{"--mapping=istio=istio-private", "--job-whitelist=integ-*,^unit-.*$", "--job-blacklist=integ-pilot-?ests", "--repo-blacklist=^too.*"},
+			equal: true,
+		},
+		{
+			name:  "idempotent",
+			args:  []string{"--mapping=istio=istio-private"},
+			runs:  2,
+			equal: true,
+		},
+		{
+			name:     "merge by name",
+			args:     []string{"--mapping=istio=istio-private"},
+			existing: true,
+			equal:    true,
+		},
+		{
+			name:     "prune",
+			args:     []string{"--mapping=istio=istio-private", "--prune"},
+			existing: true,
+			runs:     2,
+			equal:    true,
+		},
+		{
+			name:  "periodic repo",
+			args:  []string{"--mapping=istio=istio-private", "--periodic-repo=istio/test-infra", "--job-blacklist=report-*"},
+			equal: true,
+		},
+		{
+			name:  "provenance",
+			args:  []string{"--mapping=istio=istio-private", "--provenance"},
+			equal: true,
+		},
+		{
+			name:     "check",
+			args:     []string{"--mapping=istio=istio-private", "--check"},
+			existing: true,
+			equal:    true,
+		},
+		{
+			name:     "diff",
+			args:     []string{"--mapping=istio=istio-private", "--diff"},
+			existing: true,
+			equal:    true,
+		},
+		{
+			name:    "patches",
+			configs: true,
+			equal:   true,
+		},
+		{
+			name:    "image mapping",
+			configs: true,
+			equal:   true,
+		},
+		{
+			name:    "volume mapping",
+			configs: true,
+			equal:   true,
+		},
+		{
+			name:    "strip presets",
+			configs: true,
+			equal:   true,
+		},
 		{
 			name:    "config file",
 			configs: true,
@@ -159,19 +252,36 @@ func TestGenjobs(t *testing.T) {
 			defer os.Remove(tmpDir)
 			outA := filepath.Join(tmpDir, "out.yaml")
 
-			os.Args = []string{"genjobs"}
-			pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
-			os.Args = append(os.Args, test.args...)
-			if test.configs {
-				cfg, err := parseConfigTmpl(in, outA, resolvePath(t, "_cfg.yaml"), tmpDir)
+			if test.existing {
+				existing, err := ioutil.ReadFile(resolvePath(t, "_existing.yaml"))
 				if err != nil {
-					t.Fatal(err)
+					t.Fatalf("failed reading existing output file: %v", err)
+				}
+				if err := ioutil.WriteFile(outA, existing, 0644); err != nil {
+					t.Fatalf("failed writing existing output file %v: %v", outA, err)
 				}
-				os.Args = append(os.Args, "--configs="+cfg)
-			} else {
-				os.Args = append(os.Args, "--input="+in, "--output="+outA)
 			}
-			genjobs.Main()
+
+			runs := test.runs
+			if runs == 0 {
+				runs = 1
+			}
+
+			for i := 0; i < runs; i++ {
+				os.Args = []string{"genjobs"}
+				pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+				os.Args = append(os.Args, test.args...)
+				if test.configs {
+					cfg, err := parseConfigTmpl(in, outA, resolvePath(t, "_cfg.yaml"), tmpDir)
+					if err != nil {
+						t.Fatal(err)
+					}
+					os.Args = append(os.Args, "--configs="+cfg)
+				} else {
+					os.Args = append(os.Args, "--input="+in, "--output="+outA)
+				}
+				genjobs.Main()
+			}
 
 			actual, err := ioutil.ReadFile(outA)
 			if err != nil {
@@ -195,3 +305,104 @@ func TestGenjobs(t *testing.T) {
 		})
 	}
 }
+
+func TestGenjobsOutOfDate(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+		diff   bool
+	}{
+		{
+			name:   "check stale",
+			args:   []string{"--mapping=istio=istio-private", "--check"},
+			code:   1,
+			stderr: "generated job(s) are out of date: OUTPUT.\n",
+		},
+		{
+			name: "diff stale",
+			args: []string{"--mapping=istio=istio-private", "--diff"},
+			diff: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := resolvePath(t, "_in.yaml")
+			outE := resolvePath(t, "_out.yaml")
+
+			tmpDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatalf("failed creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+			outA := filepath.Join(tmpDir, "out.yaml")
+
+			existing, err := ioutil.ReadFile(resolvePath(t, "_existing.yaml"))
+			if err != nil {
+				t.Fatalf("failed reading existing output file: %v", err)
+			}
+			if err := ioutil.WriteFile(outA, existing, 0644); err != nil {
+				t.Fatalf("failed writing existing output file %v: %v", outA, err)
+			}
+
+			// os.Args is modified by other tests, so the test binary is found with os.Executable.
+			bin, err := os.Executable()
+			if err != nil {
+				t.Fatalf("failed finding test binary: %v", err)
+			}
+
+			var stdout, stderr bytes.Buffer
+			cmd := exec.Command(bin)
+			cmd.Env = append(os.Environ(), argsEnv+"="+strings.Join(append(test.args, "--input="+in, "--output="+outA), "\n"))
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+
+			code := 0
+			if err := cmd.Run(); err != nil {
+				exitErr, ok := err.(*exec.ExitError)
+				if !ok {
+					t.Fatalf("failed running genjobs: %v", err)
+				}
+				code = exitErr.ExitCode()
+			}
+
+			if code != test.code {
+				t.Errorf("expected exit status %d, got %d (stderr: %s)", test.code, code, stderr.String())
+			}
+
+			if actual := strings.Replace(stderr.String(), outA, "OUTPUT", -1); actual != test.stderr {
+				t.Errorf("expected stderr %q, got %q", test.stderr, actual)
+			}
+
+			if test.diff {
+				diffE := resolvePath(t, "_out.diff")
+				actual := strings.Replace(stdout.String(), outA, "OUTPUT", -1)
+
+				if os.Getenv("REFRESH_GOLDEN") == "true" {
+					if err := ioutil.WriteFile(diffE, []byte(actual), 0644); err != nil {
+						t.Fatalf("failed writing expected diff file %v: %v", diffE, err)
+					}
+				}
+
+				expected, err := ioutil.ReadFile(diffE)
+				if err != nil {
+					t.Fatalf("failed reading expected diff file %v: %v", diffE, err)
+				}
+				if actual != string(expected) {
+					t.Errorf("expected diff:\n%s\nactual diff:\n%s", expected, actual)
+				}
+			}
+
+			// Output files are generated in memory, so the output file on disk is left untouched.
+			expected, err := ioutil.ReadFile(outE)
+			if err != nil {
+				t.Fatalf("failed reading expected output file %v: %v", outE, err)
+			}
+			if actual, err := ioutil.ReadFile(outA); err != nil || !bytes.Equal(actual, expected) {
+				t.Errorf("expected output file to be left untouched, got: %s (%v)", actual, err)
+			}
+		})
+	}
+}
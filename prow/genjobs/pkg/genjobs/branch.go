@@ -0,0 +1,114 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/prow/genjobs/pkg/util"
+)
+
+// branchMapping is a rewrite rule for branch names.
+type branchMapping struct {
+	from string
+	to   string
+	re   *regexp.Regexp
+}
+
+// compileBranchMappings compiles a branch mapping. Keys anchored with `^` or `$` are regular expressions whose
+// replacement supports $1 expansion, and all other keys are exact branch names. Exact branch names take precedence.
+func compileBranchMappings(m map[string]string) ([]branchMapping, error) {
+	var exact, regex []branchMapping
+
+	for _, from := range util.SortedKeys(m) {
+		if !strings.HasPrefix(from, "^") && !strings.HasSuffix(from, "$") {
+			exact = append(exact, branchMapping{from: from, to: m[from]})
+			continue
+		}
+
+		re, err := regexp.Compile(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid branch mapping %q: %v", from, err)
+		}
+		regex = append(regex, branchMapping{from: from, to: m[from], re: re})
+	}
+
+	return append(exact, regex...), nil
+}
+
+// mapBranch maps a branch name with the first matching branch mapping.
+func mapBranch(o Options, branch string) (string, bool) {
+	for _, m := range o.branchMappings {
+		if m.re == nil {
+			if branch == m.from {
+				return m.to, true
+			}
+			continue
+		}
+		if m.re.MatchString(branch) {
+			return m.re.ReplaceAllString(branch, m.to), true
+		}
+	}
+
+	return branch, false
+}
+
+// mapBranchPattern maps a Brancher branch, which may be anchored to match the branch name exactly (e.g. ^master$).
+func mapBranchPattern(o Options, pattern string) string {
+	if mapped, ok := mapBranch(o, pattern); ok {
+		return mapped
+	}
+
+	branch := strings.TrimSuffix(strings.TrimPrefix(pattern, "^"), "$")
+	if branch == pattern {
+		return pattern
+	}
+
+	mapped, ok := mapBranch(o, branch)
+	if !ok {
+		return pattern
+	}
+
+	return strings.Replace(pattern, branch, mapped, 1)
+}
+
+// updateBranches maps the jobs Brancher branches based on provided inputs.
+func updateBranches(o Options, job *config.Brancher) {
+	if len(o.branchMappings) == 0 {
+		return
+	}
+
+	mapPatterns := func(patterns []string) []string {
+		if len(patterns) == 0 {
+			return patterns
+		}
+
+		mapped := make([]string, 0, len(patterns))
+		for _, p := range patterns {
+			mapped = append(mapped, mapBranchPattern(o, p))
+		}
+
+		return mapped
+	}
+
+	job.Branches = mapPatterns(job.Branches)
+	job.SkipBranches = mapPatterns(job.SkipBranches)
+}
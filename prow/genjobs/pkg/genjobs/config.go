@@ -0,0 +1,311 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/test-infra/prow/genjobs/pkg/util"
+)
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// nodeError is an error at a line of a configuration file.
+type nodeError struct {
+	line int
+	msg  string
+}
+
+func (e *nodeError) Error() string {
+	return fmt.Sprintf("%d: %s", e.line, e.msg)
+}
+
+// readConfiguration strictly reads a yaml configuration file, rejecting invalid yaml, unknown fields, mistyped fields
+// and duplicate keys with the line they are defined on.
+func readConfiguration(p string) (Configuration, error) {
+	var c Configuration
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return c, fmt.Errorf("unable to read configuration %v: %v", p, err)
+	}
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &doc); err != nil {
+		return c, fmt.Errorf("%v: %v", p, err)
+	}
+
+	for _, n := range doc.Content {
+		if err := checkNode(n, reflect.TypeOf(c), "", n.Line); err != nil {
+			return c, fmt.Errorf("%v:%v", p, err)
+		}
+	}
+
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%v: %v", p, err)
+	}
+
+	return c, nil
+}
+
+// checkNode checks that a yaml node can be strictly decoded into a value of a type, as the value of a field defined on
+// a line. Types with their own json decoding accept any node.
+func checkNode(n *yamlv3.Node, t reflect.Type, field string, line int) error {
+	if n.Kind == yamlv3.AliasNode {
+		n = n.Alias
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if n.Tag == "!!null" || t.Kind() == reflect.Interface || reflect.PtrTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+
+	var ok bool
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if ok = n.Kind == yamlv3.MappingNode; ok {
+			fields := jsonFields(t)
+			return checkMapping(n, func(key *yamlv3.Node) (reflect.Type, error) {
+				ft, ok := fields[key.Value]
+				if !ok {
+					return nil, &nodeError{line: key.Line, msg: fmt.Sprintf("unknown field %q", key.Value)}
+				}
+				return ft, nil
+			})
+		}
+	case reflect.Map:
+		if ok = n.Kind == yamlv3.MappingNode; ok {
+			return checkMapping(n, func(*yamlv3.Node) (reflect.Type, error) {
+				return t.Elem(), nil
+			})
+		}
+	case reflect.Slice, reflect.Array:
+		if ok = n.Kind == yamlv3.SequenceNode; ok {
+			for _, item := range n.Content {
+				if err := checkNode(item, t.Elem(), field, item.Line); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		ok = n.Kind == yamlv3.ScalarNode && typeName(t) == nodeTypeName(n)
+	}
+
+	if !ok {
+		return &nodeError{line: line, msg: fmt.Sprintf("field %q must be of type %v, not %v", field, typeName(t), nodeTypeName(n))}
+	}
+
+	return nil
+}
+
+// checkMapping checks the values of a mapping node, of the types of their keys, and rejects duplicate keys.
+func checkMapping(n *yamlv3.Node, valueType func(key *yamlv3.Node) (reflect.Type, error)) error {
+	lines := map[string]int{}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+
+		if line, ok := lines[key.Value]; ok {
+			return &nodeError{line: key.Line, msg: fmt.Sprintf("duplicate key %q, already defined on line %d", key.Value, line)}
+		}
+		lines[key.Value] = key.Line
+
+		t, err := valueType(key)
+		if err != nil {
+			return err
+		}
+
+		if err := checkNode(value, t, key.Value, key.Line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// jsonFields returns the types of the fields of a struct by their json name, including the fields of embedded
+// structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		if ft := f.Type; f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for k, v := range jsonFields(ft) {
+					fields[k] = v
+				}
+				continue
+			}
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+// typeName returns the json type of values of a type.
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "bool"
+	default:
+		return "string"
+	}
+}
+
+// nodeTypeName returns the json type of the value of a yaml node.
+func nodeTypeName(n *yamlv3.Node) string {
+	switch n.Kind {
+	case yamlv3.MappingNode:
+		return "object"
+	case yamlv3.SequenceNode:
+		return "array"
+	}
+
+	switch n.Tag {
+	case "!!int", "!!float":
+		return "number"
+	case "!!bool":
+		return "bool"
+	default:
+		return "string"
+	}
+}
+
+// ParseConfiguration strictly parses and validates the yaml configuration transforms. Errors are reported with the
+// file, and line or transform index, they originate from.
+func (o *Options) ParseConfiguration() ([]Options, error) {
+	var optsList []Options
+	var global Configuration
+	var err error
+
+	if o.Global != "" {
+		if global, err = readConfiguration(o.Global); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, root := range o.Configs {
+		if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() || !util.HasExtension(path, yamlExt) || filepath.Base(path) == defaultsFilename {
+				return nil
+			}
+
+			var local Configuration
+			if localPath := filepath.Join(filepath.Dir(path), defaultsFilename); util.Exists(localPath) {
+				if local, err = readConfiguration(localPath); err != nil {
+					return err
+				}
+			}
+
+			// The name of the configuration file does not depend on where the configurations are checked out.
+			name := filepath.Base(path)
+			if rel, err := filepath.Rel(root, path); err == nil && rel != "." {
+				name = rel
+			}
+
+			c, err := readConfiguration(path)
+			if err != nil {
+				return err
+			}
+
+			for i, t := range c.Transforms {
+				if len(t.JobType) == 0 {
+					t.JobType = DefaultJobTypes
+				}
+
+				applyDefaultTransforms(&t, &c.Defaults, &local.Defaults, &global.Defaults)
+
+				oc := Options{ConfigPath: path, ConfigIndex: i, ConfigName: name, Transform: t}
+
+				if err := oc.Validate(); err != nil {
+					return fmt.Errorf("%v: transform %d: %v", path, i, err)
+				}
+
+				optsList = append(optsList, oc)
+			}
+
+			return nil
+		}); err != nil {
+			return nil, &util.ExitError{Message: fmt.Sprintf("invalid configuration: %v", err), Code: 1}
+		}
+	}
+
+	return optsList, nil
+}
+
+// ReportConfiguration writes which transforms were loaded from which configuration file.
+func ReportConfiguration(w io.Writer, optsList []Options) {
+	for _, o := range optsList {
+		if o.ConfigPath == "" {
+			continue
+		}
+
+		var mapping []string
+		for _, org := range util.SortedKeys(o.OrgMap) {
+			mapping = append(mapping, org+"="+o.OrgMap[org])
+		}
+
+		fmt.Fprintf(w, "load transform %d from %v: mapping %v, input %v, output %v\n",
+			o.ConfigIndex, o.ConfigPath, strings.Join(mapping, ","), o.Input, o.Output)
+	}
+}
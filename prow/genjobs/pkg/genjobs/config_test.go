@@ -0,0 +1,242 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfiguration(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults string
+		config   string
+		err      string
+		loaded   int
+	}{
+		{
+			name: "valid",
+			config: `transforms:
+- mapping:
+    istio: istio-private
+  input: /tmp/in
+  output: /tmp/out
+- mapping:
+    istio: istio-private
+  input: /tmp/in
+  output: /tmp/out2
+`,
+			loaded: 2,
+		},
+		{
+			name: "unknown field",
+			config: `transforms:
+- mapping:
+    istio: istio-private
+  ouput: /tmp/out
+`,
+			err: "config.yaml:4: unknown field \"ouput\"",
+		},
+		{
+			name: "wrong field type",
+			config: `transforms:
+- mapping:
+    istio: istio-private
+  prune: maybe
+`,
+			err: "config.yaml:4: field \"prune\" must be of type bool, not string",
+		},
+		{
+			name: "same key in two transforms",
+			config: `transforms:
+- mapping:
+    istio: istio-private
+  prune: true
+- mapping:
+    istio: istio-secret
+  prune: maybe
+`,
+			err: "config.yaml:7: field \"prune\" must be of type bool, not string",
+		},
+		{
+			name: "same key in defaults and a transform",
+			config: `defaults:
+  bucket: istio-private-build
+transforms:
+- mapping:
+    istio: istio-private
+  bucket:
+    name: istio-private-build
+`,
+			err: "config.yaml:6: field \"bucket\" must be of type string, not object",
+		},
+		{
+			name: "invalid yaml",
+			config: `transforms:
+- mapping: [
+`,
+			err: "config.yaml: yaml: line 2: did not find expected node content",
+		},
+		{
+			name: "duplicate key",
+			config: `transforms:
+- mapping:
+    istio: istio-private
+  prune: true
+  prune: false
+`,
+			err: "config.yaml:5: duplicate key \"prune\", already defined on line 4",
+		},
+		{
+			name: "nested unknown field",
+			config: `transforms:
+- mapping:
+    istio: istio-private
+  patches:
+  - type: json
+    job-whitelist: [unit]
+    jobtype: [presubmit]
+`,
+			err: "config.yaml:7: unknown field \"jobtype\"",
+		},
+		{
+			name: "wrong item type",
+			config: `transforms:
+- mapping:
+    istio: istio-private
+  branches:
+  - master
+  - release: "1.5"
+`,
+			err: "config.yaml:6: field \"branches\" must be of type string, not object",
+		},
+		{
+			name:     "invalid defaults",
+			defaults: "defaults:\n  buckett: foo\n",
+			config:   "transforms: []\n",
+			err:      ".defaults.yaml:2: unknown field \"buckett\"",
+		},
+		{
+			name:   "invalid transform",
+			config: "transforms:\n- input: /tmp/in\n- output: /tmp/out\n",
+			err:    "config.yaml: transform 0: -m, --mapping option is required.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatalf("failed creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			if test.defaults != "" {
+				if err := ioutil.WriteFile(filepath.Join(tmpDir, defaultsFilename), []byte(test.defaults), 0644); err != nil {
+					t.Fatalf("failed writing defaults: %v", err)
+				}
+			}
+			if err := ioutil.WriteFile(filepath.Join(tmpDir, "config.yaml"), []byte(test.config), 0644); err != nil {
+				t.Fatalf("failed writing config: %v", err)
+			}
+
+			o := Options{Configs: []string{tmpDir}}
+			configs, err := o.ParseConfiguration()
+
+			if test.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), test.err) {
+					t.Fatalf("expected error ending with %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(configs) != test.loaded {
+				t.Fatalf("expected %d transforms loaded, got %d", test.loaded, len(configs))
+			}
+		})
+	}
+}
+
+func TestParseConfigurationProvenance(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := filepath.Join(tmpDir, "istio-private_jobs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed creating config dir: %v", err)
+	}
+	config := "transforms:\n- mapping:\n    istio: istio-private\n  input: /tmp/in\n  output: /tmp/out\n  provenance: true\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "istio.yaml"), []byte(config), 0644); err != nil {
+		t.Fatalf("failed writing config: %v", err)
+	}
+
+	// The transform annotation is relative to the absolute --configs path, whether it is a directory or a file.
+	for configs, expected := range map[string]string{
+		tmpDir:                           "istio-private_jobs/istio.yaml",
+		dir:                              "istio.yaml",
+		filepath.Join(dir, "istio.yaml"): "istio.yaml",
+	} {
+
+		o := Options{Configs: []string{configs}}
+		optsList, err := o.ParseConfiguration()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(optsList) != 1 {
+			t.Fatalf("expected 1 transform loaded, got %d", len(optsList))
+		}
+
+		pre := newPresubmit("unit")
+		set := &JobSet{Source: "istio/istio.yaml", Hash: "sha256:0123", Jobs: []*Job{{OrgRepo: "istio/istio", Presubmit: &pre}}}
+		if err := TransformJobs(optsList[0], set); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if actual := pre.Annotations["genjobs.istio.io/transform"]; actual != expected {
+			t.Errorf("Actual: %v ; Expected: %v", actual, expected)
+		}
+	}
+}
+
+func TestReportConfiguration(t *testing.T) {
+	optsList := []Options{
+		{Transform: Transform{Input: "/tmp/cli"}},
+		{ConfigPath: "/tmp/config.yaml", ConfigIndex: 1, Transform: Transform{
+			OrgMap: map[string]string{"istio": "istio-private", "envoyproxy": "envoy-private"},
+			Input:  "/tmp/in",
+			Output: "/tmp/out",
+		}},
+	}
+
+	var buf bytes.Buffer
+	ReportConfiguration(&buf, optsList)
+
+	expected := "load transform 1 from /tmp/config.yaml: mapping envoyproxy=envoy-private,istio=istio-private, input /tmp/in, output /tmp/out\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
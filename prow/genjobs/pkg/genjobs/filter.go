@@ -0,0 +1,260 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	prowjob "k8s.io/test-infra/prow/apis/prowjobs/v1"
+
+	"istio.io/test-infra/prow/genjobs/pkg/util"
+)
+
+// validateOrgRepo validates that the org and repo for a job pass validation and should be converted.
+func validateOrgRepo(o Options, org string, repo string) bool {
+	valid, _ := explainOrgRepo(o, org, repo)
+	return valid
+}
+
+// explainOrgRepo validates the org and repo for a job and returns the rule that decided the outcome.
+func explainOrgRepo(o Options, org string, repo string) (bool, string) {
+	if _, hasOrg := o.OrgMap[org]; !hasOrg {
+		return false, fmt.Sprintf("org %q has no mapping", org)
+	}
+
+	if rule, ok := o.repoBlacklistSet.Match(repo); ok {
+		return false, fmt.Sprintf("repo %q matched repo-blacklist rule %q", repo, rule)
+	}
+
+	if len(o.repoWhitelistSet) > 0 {
+		rule, ok := o.repoWhitelistSet.Match(repo)
+		if !ok {
+			return false, fmt.Sprintf("repo %q matched no repo-whitelist rule", repo)
+		}
+		return true, fmt.Sprintf("repo %q matched repo-whitelist rule %q", repo, rule)
+	}
+
+	return true, ""
+}
+
+// validateJob validates that the job passes validation and should be converted.
+func validateJob(o Options, name string, patterns []string, jType string, props jobProperties) (bool, string) {
+	if rule, ok := o.jobBlacklistSet.Match(name); ok {
+		return false, fmt.Sprintf("matched job-blacklist rule %q", rule)
+	}
+
+	var reason string
+
+	if len(o.jobWhitelistSet) > 0 {
+		rule, ok := o.jobWhitelistSet.Match(name)
+		if !ok {
+			return false, "matched no job-whitelist rule"
+		}
+		reason = fmt.Sprintf("matched job-whitelist rule %q", rule)
+	}
+
+	if !isMatchBranch(o, patterns) {
+		return false, fmt.Sprintf("branches %v matched no branch in %v", patterns, o.Branches)
+	}
+
+	if !o.jobTypeSet.Has(jType) {
+		return false, fmt.Sprintf("job type %q is not in %v", jType, o.jobTypeSet.List())
+	}
+
+	if ok, sel := isMatchSelectors(o.includeSelectors, o.excludeSelectors, props); !ok {
+		return false, sel
+	}
+
+	return true, reason
+}
+
+// isMatchSelectors validates that the job matches all include selectors and none of the exclude selectors.
+func isMatchSelectors(include []selector, exclude []selector, props jobProperties) (bool, string) {
+	for _, sel := range include {
+		if !sel.matches(props) {
+			return false, fmt.Sprintf("did not match include selector %q", sel)
+		}
+	}
+
+	for _, sel := range exclude {
+		if sel.matches(props) {
+			return false, fmt.Sprintf("matched exclude selector %q", sel)
+		}
+	}
+
+	return true, ""
+}
+
+// explainJob prints which rule(s) kept or dropped a job when in verbose mode.
+func explainJob(o Options, jType string, name string, kept bool, reasons ...string) {
+	if !o.Verbose {
+		return
+	}
+
+	action := "drop"
+	if kept {
+		action = "keep"
+	}
+
+	var because []string
+	for _, r := range reasons {
+		if r != "" {
+			because = append(because, r)
+		}
+	}
+	if len(because) == 0 {
+		because = append(because, "passed all filters")
+	}
+
+	fmt.Printf("%s %s %v: %v\n", action, jType, name, strings.Join(because, "; "))
+}
+
+// isMatchBranch validates that the branch for a job passes validation and should be converted.
+func isMatchBranch(o Options, patterns []string) bool {
+	if len(o.Branches) == 0 {
+		return true
+	}
+
+	for _, branch := range o.Branches {
+		for _, pattern := range patterns {
+			if regexp.MustCompile(pattern).MatchString(branch) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// allRefs returns true if all predicate function returns true for the array of ref.
+func allRefs(array []prowjob.Refs, predicate func(val prowjob.Refs, idx int) bool) bool {
+	for idx, item := range array {
+		if !predicate(item, idx) {
+			return false
+		}
+	}
+	return true
+}
+
+// convertOrgRepoStr translates the provided job org and repo based on the specified org mapping.
+func convertOrgRepoStr(o Options, s string) string {
+	org, repo := util.SplitOrgRepo(s)
+
+	valid := validateOrgRepo(o, org, repo)
+
+	if !valid {
+		return ""
+	}
+
+	return strings.Join([]string{o.OrgMap[org], repo}, "/")
+}
+
+// FilterJobs drops the jobs which do not pass the org mapping, repo, job, branch, job type and selector filters, and
+// records the patches whose filters each kept job passes.
+func FilterJobs(o Options, set *JobSet) error {
+	var kept []*Job
+
+	for _, job := range set.Jobs {
+		if filterJob(o, job) {
+			kept = append(kept, job)
+		}
+	}
+
+	set.Jobs = kept
+
+	return nil
+}
+
+// filterJob validates that a job passes all filters, explaining the outcome when in verbose mode.
+func filterJob(o Options, job *Job) bool {
+	jType := job.Type()
+	base := job.Base()
+	props := newJobProperties(*base)
+
+	var repos []string
+
+	switch {
+	case job.Presubmit != nil, job.Postsubmit != nil:
+		org, repo := util.SplitOrgRepo(job.OrgRepo)
+		validRepo, repoReason := explainOrgRepo(o, org, repo)
+		if !validRepo {
+			explainJob(o, jType, base.Name, false, repoReason)
+			return false
+		}
+
+		if job.Presubmit != nil {
+			props.AlwaysRun = job.Presubmit.AlwaysRun
+			props.Optional = job.Presubmit.Optional
+		} else {
+			props.AlwaysRun = job.Postsubmit.RunIfChanged == ""
+		}
+
+		valid, reason := validateJob(o, base.Name, job.Brancher().Branches, jType, props)
+		explainJob(o, jType, base.Name, valid, repoReason, reason)
+		if !valid {
+			return false
+		}
+
+		repos = []string{repo}
+	default:
+		props.AlwaysRun = true
+
+		valid, reason := validateJob(o, base.Name, []string{}, jType, props)
+		if !valid {
+			explainJob(o, jType, base.Name, false, reason)
+			return false
+		}
+
+		if len(job.Periodic.ExtraRefs) == 0 {
+			if o.PeriodicRepo == "" {
+				explainJob(o, jType, base.Name, false, "has no extra refs")
+				return false
+			}
+
+			org, repo := util.SplitOrgRepo(o.PeriodicRepo)
+			validRepo, repoReason := explainOrgRepo(o, org, repo)
+			if !validRepo {
+				explainJob(o, jType, base.Name, false, "has no extra refs", repoReason)
+				return false
+			}
+
+			explainJob(o, jType, base.Name, true, fmt.Sprintf("has no extra refs and is assigned to repo %v", o.PeriodicRepo), reason)
+
+			repos = []string{repo}
+			break
+		}
+
+		if allRefs(job.Periodic.ExtraRefs, func(val prowjob.Refs, idx int) bool {
+			return !validateOrgRepo(o, val.Org, val.Repo)
+		}) {
+			explainJob(o, jType, base.Name, false, "no extra ref passed the org mapping and repo filters")
+			return false
+		}
+
+		explainJob(o, jType, base.Name, true, reason)
+
+		for _, ref := range job.Periodic.ExtraRefs {
+			repos = append(repos, ref.Repo)
+		}
+	}
+
+	job.patches = matchingPatches(o, base.Name, repos, jType, props)
+
+	return true
+}
@@ -0,0 +1,368 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/prow/genjobs/pkg/util"
+)
+
+// Job is a presubmit, postsubmit or periodic job being generated. Exactly one of Presubmit, Postsubmit or Periodic is
+// set.
+type Job struct {
+	// OrgRepo is the org/repo of a presubmit or postsubmit, which is translated by TransformJobs.
+	OrgRepo    string
+	Presubmit  *config.Presubmit
+	Postsubmit *config.Postsubmit
+	Periodic   *config.Periodic
+	// Output is the output file the job is written to when it differs from the output file of its job set.
+	Output string
+	// Original is the name of the job before it is transformed, recorded by TransformJobs.
+	Original string
+
+	// patches are the patches whose filters the original job passed.
+	patches []compiledPatch
+}
+
+// Type returns the job type (e.g. presubmit, postsubmit, periodic).
+func (j *Job) Type() string {
+	switch {
+	case j.Presubmit != nil:
+		return "presubmit"
+	case j.Postsubmit != nil:
+		return "postsubmit"
+	default:
+		return "periodic"
+	}
+}
+
+// Base returns the JobBase of the job.
+func (j *Job) Base() *config.JobBase {
+	switch {
+	case j.Presubmit != nil:
+		return &j.Presubmit.JobBase
+	case j.Postsubmit != nil:
+		return &j.Postsubmit.JobBase
+	default:
+		return &j.Periodic.JobBase
+	}
+}
+
+// UtilityConfig returns the UtilityConfig of the job.
+func (j *Job) UtilityConfig() *config.UtilityConfig {
+	switch {
+	case j.Presubmit != nil:
+		return &j.Presubmit.UtilityConfig
+	case j.Postsubmit != nil:
+		return &j.Postsubmit.UtilityConfig
+	default:
+		return &j.Periodic.UtilityConfig
+	}
+}
+
+// Brancher returns the Brancher of the job, or nil for a periodic.
+func (j *Job) Brancher() *config.Brancher {
+	switch {
+	case j.Presubmit != nil:
+		return &j.Presubmit.Brancher
+	case j.Postsubmit != nil:
+		return &j.Postsubmit.Brancher
+	default:
+		return nil
+	}
+}
+
+// object returns a pointer to the underlying Presubmit, Postsubmit or Periodic.
+func (j *Job) object() interface{} {
+	switch {
+	case j.Presubmit != nil:
+		return j.Presubmit
+	case j.Postsubmit != nil:
+		return j.Postsubmit
+	default:
+		return j.Periodic
+	}
+}
+
+// JobSet is the jobs loaded from a single input file, which are generated into a single output file.
+type JobSet struct {
+	// Input is the absolute path of the input file.
+	Input string
+	// Output is the path of the output file.
+	Output string
+	// Source is the input path relative to the input directory, recorded on jobs for pruning.
+	Source string
+	// Hash is the sha256 hash of the input file content, recorded on jobs for provenance.
+	Hash string
+	// Presets are the presets available to the jobs, including those defined in the input file.
+	Presets []config.Preset
+	// Jobs are the jobs of the input file, in the order they are defined for each org/repo.
+	Jobs []*Job
+	// Truncated are the jobs whose name is truncated by TransformJobs.
+	Truncated []Truncated
+}
+
+// Truncated is a job whose name is truncated, and the original name it is generated from.
+type Truncated struct {
+	Type     string
+	OrgRepo  string
+	Original string
+	Name     string
+	// Output is the output file the job is written to.
+	Output string
+}
+
+// Stage is a step of the generation pipeline which is run on each job set.
+type Stage func(o Options, set *JobSet) error
+
+// ConflictError is returned when the presets of one or more jobs can not be resolved.
+type ConflictError struct {
+	Conflicts []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("unable to resolve presets for job(s):\n  %v", strings.Join(e.Conflicts, "\n  "))
+}
+
+// Generator generates jobs by loading each input file into a job set and running it through a pipeline of stages.
+// Jobs generated from all options are checked for name collisions and share the same outputs.
+type Generator struct {
+	// Stages are run in order on each job set. By default, jobs are filtered, transformed, resolved, pruned, have
+	// their volumes and images mapped, and are patched. Job sets are processed concurrently, so stages must be safe
+	// for concurrent use.
+	Stages []Stage
+	// Write is run on each job set after the stages, one job set at a time and in a deterministic order: options in
+	// the order they are given and input files in lexical order. By default, jobs are written to the output files.
+	Write Stage
+	// Parallelism is the maximum number of job sets processed concurrently.
+	Parallelism int
+
+	in        *inputs
+	reg       *registry
+	out       *outputs
+	truncated []Truncated
+}
+
+// NewGenerator creates a generator with the default stages. In memory, output files are generated in memory rather
+// than written to disk so they can be compared against the files on disk.
+func NewGenerator(inMemory bool) *Generator {
+	g := &Generator{Parallelism: runtime.NumCPU(), in: newInputs(), reg: newRegistry(), out: newOutputs(inMemory)}
+	g.Stages = []Stage{FilterJobs, TransformJobs, ResolveJobs, PruneJobs, MapVolumes, MapImages, PatchJobs}
+	g.Write = g.WriteJobs
+
+	return g
+}
+
+// LoadJobs loads the jobs of an input file into a job set. A nil job set is returned for a file which is not converted.
+func LoadJobs(o Options, p string) (*JobSet, error) {
+	return loadJobs(o, p, newInputs())
+}
+
+// loadJobs loads the jobs of an input file into a job set, reading the input file from the input cache.
+func loadJobs(o Options, p string, in *inputs) (*JobSet, error) {
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !util.HasExtension(absPath, yamlExt) {
+		return nil, nil
+	}
+
+	outPath := getOutPath(o, absPath, o.Input)
+	if outPath == "" {
+		return nil, nil
+	}
+
+	jobs, hash, err := in.read(absPath)
+	if err != nil {
+		return nil, nil
+	}
+
+	set := &JobSet{
+		Input:   absPath,
+		Output:  outPath,
+		Source:  getSource(o, absPath),
+		Hash:    hash,
+		Presets: append(append([]config.Preset{}, o.presets...), jobs.Presets...),
+	}
+
+	for orgrepo, pre := range jobs.PresubmitsStatic {
+		for i := range pre {
+			set.Jobs = append(set.Jobs, &Job{OrgRepo: orgrepo, Presubmit: &pre[i]})
+		}
+	}
+
+	for orgrepo, post := range jobs.PostsubmitsStatic {
+		for i := range post {
+			set.Jobs = append(set.Jobs, &Job{OrgRepo: orgrepo, Postsubmit: &post[i]})
+		}
+	}
+
+	for i := range jobs.Periodics {
+		set.Jobs = append(set.Jobs, &Job{Periodic: &jobs.Periodics[i]})
+	}
+
+	return set, nil
+}
+
+// task is an input file of a set of options to generate jobs for, and the result of running the stages on it.
+type task struct {
+	o    Options
+	path string
+	set  *JobSet
+	err  error
+}
+
+// run loads the input file of a task and runs the stages on its job set.
+func (g *Generator) run(t *task) {
+	set, err := loadJobs(t.o, t.path, g.in)
+	if err != nil || set == nil {
+		t.err = err
+		return
+	}
+
+	for _, stage := range g.Stages {
+		if err := stage(t.o, set); err != nil {
+			t.err = err
+			return
+		}
+	}
+
+	t.set = set
+}
+
+// Generate loads every input file of each set of options and runs its jobs through the pipeline. Input files are
+// processed concurrently, and the job sets written in a deterministic order so the output does not depend on
+// scheduling. Output files with jobs whose presets can not be resolved are left untouched, and the conflicts of all
+// jobs are reported.
+func (g *Generator) Generate(optsList ...Options) error {
+	var tasks []*task
+
+	for _, o := range optsList {
+		if err := filepath.Walk(o.Input, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				tasks = append(tasks, &task{o: o, path: p})
+			}
+			return nil
+		}); err != nil {
+			return &util.ExitError{Message: fmt.Sprintf("unable to generate jobs: %v.", err), Code: 1}
+		}
+	}
+
+	parallelism := g.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	queue := make(chan *task)
+	var wg sync.WaitGroup
+
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				g.run(t)
+			}
+		}()
+	}
+
+	for _, t := range tasks {
+		queue <- t
+	}
+	close(queue)
+	wg.Wait()
+
+	var conflicts []string
+
+	for _, t := range tasks {
+		if c, ok := t.err.(*ConflictError); ok {
+			conflicts = append(conflicts, c.Conflicts...)
+			continue
+		}
+		if t.err == nil && t.set != nil && g.Write != nil {
+			if t.err = g.Write(t.o, t.set); t.err == nil {
+				g.truncated = append(g.truncated, t.set.Truncated...)
+			}
+		}
+		if t.err != nil {
+			return &util.ExitError{Message: fmt.Sprintf("unable to generate jobs: %v.", t.err), Code: 1}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return &util.ExitError{Message: (&ConflictError{Conflicts: conflicts}).Error(), Code: 1}
+	}
+
+	return nil
+}
+
+// Truncated returns the jobs written during the run whose name is truncated, sorted by output file, type, org/repo
+// and original name.
+func (g *Generator) Truncated() []Truncated {
+	truncated := append([]Truncated{}, g.truncated...)
+
+	sort.Slice(truncated, func(i, j int) bool {
+		a, b := truncated[i], truncated[j]
+		if a.Output != b.Output {
+			return a.Output < b.Output
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.OrgRepo != b.OrgRepo {
+			return a.OrgRepo < b.OrgRepo
+		}
+		return a.Original < b.Original
+	})
+
+	return truncated
+}
+
+// ReportTruncated writes the mapping from the original to the truncated name of each truncated job.
+func ReportTruncated(w io.Writer, truncated []Truncated) {
+	for _, t := range truncated {
+		job := t.Type
+		if t.OrgRepo != "" {
+			job += " of " + t.OrgRepo
+		}
+
+		fmt.Fprintf(w, "truncate %v name %v to %v in %v\n", job, t.Original, t.Name, t.Output)
+	}
+}
+
+// Changed returns the paths of the output files generated in memory that differ from the files on disk.
+func (g *Generator) Changed() []string {
+	return g.out.changed()
+}
+
+// Diff writes a unified diff between the files on disk and the output files generated in memory.
+func (g *Generator) Diff(w io.Writer) error {
+	return g.out.diff(w)
+}
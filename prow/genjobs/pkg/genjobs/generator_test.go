@@ -0,0 +1,775 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	prowjob "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	"k8s.io/test-infra/prow/config"
+	"sigs.k8s.io/yaml"
+)
+
+func newTestOptions(t *testing.T, tr Transform) Options {
+	if tr.Modifier == "" {
+		tr.Modifier = DefaultModifier
+	}
+	if len(tr.JobType) == 0 {
+		tr.JobType = DefaultJobTypes
+	}
+
+	o := Options{Transform: tr}
+	if err := o.Validate(); err != nil {
+		t.Fatalf("unexpected error validating options: %v", err)
+	}
+
+	return o
+}
+
+func newPresubmit(name string) config.Presubmit {
+	return config.Presubmit{
+		JobBase: config.JobBase{
+			Name: name,
+			Spec: &v1.PodSpec{Containers: []v1.Container{{Image: "gcr.io/istio-testing/build-tools:latest"}}},
+		},
+		Brancher: config.Brancher{Branches: []string{"^master$"}},
+	}
+}
+
+func jobNames(set *JobSet) []string {
+	var names []string
+	for _, job := range set.Jobs {
+		names = append(names, job.OrgRepo+"/"+job.Base().Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFilterJobs(t *testing.T) {
+	o := newTestOptions(t, Transform{
+		OrgMap:       map[string]string{"istio": "istio-private"},
+		JobBlacklist: []string{"lint-*"},
+		Exclude:      []string{"label:skip=true"},
+	})
+
+	skipped := newPresubmit("unit-skipped")
+	skipped.Labels = map[string]string{"skip": "true"}
+
+	set := &JobSet{}
+	for _, job := range []struct {
+		orgrepo string
+		job     config.Presubmit
+	}{
+		{"istio/istio", newPresubmit("unit")},
+		{"istio/istio", newPresubmit("lint-go")},
+		{"istio/istio", skipped},
+		{"kubernetes/test-infra", newPresubmit("unit")},
+	} {
+		job := job
+		set.Jobs = append(set.Jobs, &Job{OrgRepo: job.orgrepo, Presubmit: &job.job})
+	}
+
+	if err := FilterJobs(o, set); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"istio/istio/unit"}
+	if actual := jobNames(set); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Actual: %v ; Expected: %v", actual, expected)
+	}
+}
+
+func TestTransformJobs(t *testing.T) {
+	o := newTestOptions(t, Transform{
+		OrgMap:    map[string]string{"istio": "istio-private"},
+		BranchMap: map[string]string{"release-1.5": "private-release-1.5"},
+	})
+
+	pre := newPresubmit("unit")
+	pre.ExtraRefs = []prowjob.Refs{{Org: "istio", Repo: "test-infra", BaseRef: "release-1.5"}}
+
+	set := &JobSet{Jobs: []*Job{{OrgRepo: "istio/istio", Presubmit: &pre}}}
+
+	if err := TransformJobs(o, set); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"istio-private/istio/unit_private"}
+	if actual := jobNames(set); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Actual: %v ; Expected: %v", actual, expected)
+	}
+
+	if ref := pre.ExtraRefs[0]; ref.Org != "istio-private" || ref.BaseRef != "private-release-1.5" {
+		t.Errorf("Actual extra ref: %v/%v@%v ; Expected: istio-private/test-infra@private-release-1.5", ref.Org, ref.Repo, ref.BaseRef)
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	long := "e2e-bookInfoTests-envoyv2-v1alpha3_istio_release-1.4_postsubmit"
+
+	tests := []struct {
+		name     string
+		maxLen   int
+		expected string
+	}{
+		{
+			name:     "short",
+			maxLen:   58,
+			expected: "short",
+		},
+		{
+			name:     long,
+			maxLen:   len(long),
+			expected: long,
+		},
+		{
+			name:     long,
+			maxLen:   58,
+			expected: "e2e-bookInfoTests-envoyv2-v1alpha3_istio_release-1.4_posts",
+		},
+	}
+
+	for _, test := range tests {
+		if actual := truncateName(test.name, test.maxLen); actual != test.expected {
+			t.Errorf("Actual: %v ; Expected: %v", actual, test.expected)
+		}
+	}
+}
+
+func TestHashName(t *testing.T) {
+	long := "e2e-bookInfoTests-envoyv2-v1alpha3_istio_release-1.4_postsubmit"
+
+	tests := []struct {
+		name     string
+		maxLen   int
+		expected string
+	}{
+		{
+			name:     long,
+			maxLen:   len(long),
+			expected: long,
+		},
+		{
+			name:     long,
+			maxLen:   58,
+			expected: "e2e-bookInfoTests-envoyv2-v1alpha3_istio_release-62835523",
+		},
+		{
+			name:     "e2e-bookInfoTests-envoyv2-v1alpha3_istio_release-1.5_postsubmit",
+			maxLen:   58,
+			expected: "e2e-bookInfoTests-envoyv2-v1alpha3_istio_release-dfb84f0e",
+		},
+	}
+
+	for _, test := range tests {
+		if actual := hashName(test.name, test.maxLen); actual != test.expected {
+			t.Errorf("Actual: %v ; Expected: %v", actual, test.expected)
+		}
+	}
+}
+
+func TestTransformJobsNameCollision(t *testing.T) {
+	o := newTestOptions(t, Transform{
+		OrgMap: map[string]string{"istio": "istio-private"},
+	})
+
+	long := strings.Repeat("a", maxLabelLen)
+	maxLen := maxLabelLen - len(jobnameSeparator+DefaultModifier)
+
+	first := newPresubmit(long + "-first")
+	second := newPresubmit(long + "-second")
+	duplicate := newPresubmit(long + "-first")
+	duplicate.Branches = []string{"^release-1.5$"}
+	other := newPresubmit("b" + long)
+
+	set := &JobSet{Jobs: []*Job{
+		{OrgRepo: "istio/istio", Presubmit: &first},
+		{OrgRepo: "istio/istio", Presubmit: &second},
+		{OrgRepo: "istio/istio", Presubmit: &duplicate},
+		{OrgRepo: "istio/istio", Presubmit: &other},
+	}}
+
+	if err := TransformJobs(o, set); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if first.Name == second.Name || first.Name != duplicate.Name || len(first.Name) > maxLabelLen {
+		t.Errorf("expected distinct names of at most %d characters, got %v, %v and %v", maxLabelLen, first.Name, second.Name, duplicate.Name)
+	}
+
+	// Only colliding names are hashed.
+	if expected := hashName(long+"-first", maxLen) + jobnameSeparator + DefaultModifier; first.Name != expected {
+		t.Errorf("Actual: %v ; Expected: %v", first.Name, expected)
+	}
+	if expected := truncateName("b"+long, maxLen) + jobnameSeparator + DefaultModifier; other.Name != expected {
+		t.Errorf("Actual: %v ; Expected: %v", other.Name, expected)
+	}
+
+	// A job generated with the hashed name of a colliding truncated job collides with it.
+	colliding := newPresubmit(hashName(long+"-first", maxLen))
+	first.Name, second.Name = long+"-first", long+"-second"
+	set = &JobSet{Jobs: []*Job{
+		{OrgRepo: "istio/istio", Presubmit: &first},
+		{OrgRepo: "istio/istio", Presubmit: &second},
+		{OrgRepo: "istio/istio", Presubmit: &colliding},
+	}}
+
+	if err := TransformJobs(o, set); err == nil {
+		t.Error("expected an error for colliding job names")
+	}
+}
+
+func TestProvenanceAnnotations(t *testing.T) {
+	o := newTestOptions(t, Transform{
+		OrgMap:      map[string]string{"istio": "istio-private"},
+		Annotations: map[string]string{"testgrid-create-test-group": "false"},
+		Provenance:  true,
+	})
+	o.ConfigPath = "/home/prow/go/src/istio.io/test-infra/prow/config/istio-private_jobs/istio.yaml"
+	o.ConfigName = "istio-private_jobs/istio.yaml"
+
+	pre := newPresubmit("unit")
+
+	set := &JobSet{Source: "istio/istio.yaml", Hash: "sha256:0123", Jobs: []*Job{{OrgRepo: "istio/istio", Presubmit: &pre}}}
+
+	if err := TransformJobs(o, set); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"testgrid-create-test-group":   "false",
+		"genjobs.istio.io/source":      "istio/istio.yaml",
+		"genjobs.istio.io/source-job":  "unit",
+		"genjobs.istio.io/source-hash": "sha256:0123",
+		"genjobs.istio.io/transform":   "istio-private_jobs/istio.yaml",
+	}
+	if !reflect.DeepEqual(pre.Annotations, expected) {
+		t.Errorf("Actual: %v ; Expected: %v", pre.Annotations, expected)
+	}
+
+	if len(o.Annotations) != 1 {
+		t.Errorf("expected option annotations to be left unmodified, got %v", o.Annotations)
+	}
+}
+
+func TestResolveJobs(t *testing.T) {
+	o := newTestOptions(t, Transform{
+		OrgMap:  map[string]string{"istio": "istio-private"},
+		Resolve: true,
+	})
+
+	preset := config.Preset{
+		Labels: map[string]string{"preset-proxy": "true"},
+		Env:    []v1.EnvVar{{Name: "GOPROXY", Value: "https://proxy.golang.org"}},
+	}
+
+	resolved := newPresubmit("resolved")
+	resolved.Labels = map[string]string{"preset-proxy": "true"}
+
+	conflicting := newPresubmit("conflicting")
+	conflicting.Labels = map[string]string{"preset-proxy": "true"}
+	conflicting.Spec.Containers[0].Env = []v1.EnvVar{{Name: "GOPROXY", Value: "off"}}
+
+	set := &JobSet{
+		Presets: []config.Preset{preset},
+		Jobs: []*Job{
+			{OrgRepo: "istio/istio", Presubmit: &resolved},
+			{OrgRepo: "istio/istio", Presubmit: &conflicting},
+		},
+	}
+
+	err := ResolveJobs(o, set)
+
+	conflictErr, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("expected conflict error, got: %v", err)
+	}
+
+	expectedConflicts := []string{"presubmit conflicting: failed to merge presets for podspec: env var duplicated in pod spec: GOPROXY"}
+	if !reflect.DeepEqual(conflictErr.Conflicts, expectedConflicts) {
+		t.Errorf("Actual: %v ; Expected: %v", conflictErr.Conflicts, expectedConflicts)
+	}
+
+	expected := []string{"istio/istio/resolved"}
+	if actual := jobNames(set); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Actual: %v ; Expected: %v", actual, expected)
+	}
+
+	if env := resolved.Spec.Containers[0].Env; len(env) != 1 || env[0].Name != "GOPROXY" {
+		t.Errorf("expected preset env to be resolved, got: %v", env)
+	}
+}
+
+func TestGeneratorInMemory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	out := filepath.Join(tmpDir, "out.yaml")
+
+	o := newTestOptions(t, Transform{
+		OrgMap: map[string]string{"istio": "istio-private"},
+		Input:  "../../testdata/simple_transform/simple_transform_in.yaml",
+		Output: out,
+	})
+
+	var loaded int
+
+	g := NewGenerator(true)
+	g.Stages = append([]Stage{func(o Options, set *JobSet) error {
+		loaded += len(set.Jobs)
+		return nil
+	}}, g.Stages...)
+
+	if err := g.Generate(o); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if loaded == 0 {
+		t.Error("expected custom stage to be run on the loaded jobs")
+	}
+
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected output file %v not to be written to disk", out)
+	}
+
+	if changed := g.Changed(); !reflect.DeepEqual(changed, []string{out}) {
+		t.Errorf("Actual: %v ; Expected: %v", changed, []string{out})
+	}
+
+	expected, err := ioutil.ReadFile("../../testdata/simple_transform/simple_transform_out.yaml")
+	if err != nil {
+		t.Fatalf("failed reading expected output file: %v", err)
+	}
+
+	if actual := g.out.files[out]; string(actual) != string(expected) {
+		t.Errorf("expected output:\n%s\nactual output:\n%s", expected, actual)
+	}
+}
+
+func TestGeneratorParallel(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	out := filepath.Join(tmpDir, "out.yaml")
+
+	var optsList []Options
+	for _, modifier := range []string{"private", "staging", "release", "testing"} {
+		optsList = append(optsList, newTestOptions(t, Transform{
+			OrgMap:   map[string]string{"istio": "istio-private"},
+			Input:    "../../testdata/simple_transform/simple_transform_in.yaml",
+			Output:   out,
+			Modifier: modifier,
+			Sort:     "asc",
+		}))
+	}
+
+	generate := func(parallelism int) ([]byte, int) {
+		var loaded int32
+
+		g := NewGenerator(true)
+		g.Parallelism = parallelism
+		g.Stages = append([]Stage{func(o Options, set *JobSet) error {
+			atomic.AddInt32(&loaded, 1)
+			return nil
+		}}, g.Stages...)
+
+		if err := g.Generate(optsList...); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return g.out.files[out], int(loaded)
+	}
+
+	expected, loaded := generate(1)
+	if loaded != len(optsList) {
+		t.Errorf("expected %d job sets to be loaded, got %d", len(optsList), loaded)
+	}
+
+	for i := 0; i < 10; i++ {
+		if actual, _ := generate(len(optsList)); string(actual) != string(expected) {
+			t.Fatalf("expected output:\n%s\nactual output:\n%s", expected, actual)
+		}
+	}
+}
+
+func TestInputsRead(t *testing.T) {
+	in := newInputs()
+	p := "../../testdata/simple_transform/simple_transform_in.yaml"
+
+	first, hash, err := in.read(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for orgrepo := range first.PresubmitsStatic {
+		first.PresubmitsStatic[orgrepo][0].Name = "modified"
+	}
+
+	second, secondHash, err := in.read(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hash != secondHash {
+		t.Errorf("expected hash %v, got %v", hash, secondHash)
+	}
+
+	for orgrepo := range second.PresubmitsStatic {
+		if name := second.PresubmitsStatic[orgrepo][0].Name; name == "modified" {
+			t.Errorf("expected cached input not to be modified by a previous read of %v", orgrepo)
+		}
+	}
+}
+
+func TestGeneratorPeriodicRepo(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	o := newTestOptions(t, Transform{
+		OrgMap:       map[string]string{"istio": "istio-private"},
+		Input:        "../../testdata/periodic_repo/periodic_repo_in.yaml",
+		Output:       tmpDir,
+		PeriodicRepo: "istio/test-infra",
+	})
+
+	g := NewGenerator(true)
+
+	if err := g.Generate(o); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(tmpDir, "istio-private/test-infra/private.periodic_repo_in.yaml"),
+		filepath.Join(tmpDir, "private.periodic_repo_in.yaml"),
+	}
+	if actual := g.out.paths(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Actual: %v ; Expected: %v", actual, expected)
+	}
+
+	var jobs config.JobConfig
+	if err := yaml.Unmarshal(g.out.files[expected[0]], &jobs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, job := range jobs.Periodics {
+		names = append(names, job.Name)
+	}
+
+	if expectedNames := []string{"cleanup-stale-clusters_private", "report-flakes_private"}; !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Actual: %v ; Expected: %v", names, expectedNames)
+	}
+}
+
+func TestGeneratorCleanPeriodicRepo(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoOut := filepath.Join(tmpDir, "istio-private/test-infra/private.periodic_repo_in.yaml")
+	if err := os.MkdirAll(filepath.Dir(repoOut), 0755); err != nil {
+		t.Fatalf("failed creating output dir: %v", err)
+	}
+	stale := "periodics:\n- name: stale-periodic\n  interval: 1h\n  spec:\n    containers:\n    - image: alpine\n"
+	if err := ioutil.WriteFile(repoOut, []byte(stale), 0644); err != nil {
+		t.Fatalf("failed writing existing output file: %v", err)
+	}
+
+	// Both options assign periodics to the same repo output file, which is only cleaned before the first is written.
+	var optsList []Options
+	for _, whitelist := range []string{"^cleanup-.*$", "^report-.*$"} {
+		optsList = append(optsList, newTestOptions(t, Transform{
+			OrgMap:       map[string]string{"istio": "istio-private"},
+			Input:        "../../testdata/periodic_repo/periodic_repo_in.yaml",
+			Output:       tmpDir,
+			PeriodicRepo: "istio/test-infra",
+			JobWhitelist: []string{whitelist},
+			Clean:        true,
+		}))
+	}
+
+	g := NewGenerator(true)
+
+	if err := g.Generate(optsList...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var jobs config.JobConfig
+	if err := yaml.Unmarshal(g.out.files[repoOut], &jobs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, job := range jobs.Periodics {
+		names = append(names, job.Name)
+	}
+
+	if expectedNames := []string{"cleanup-stale-clusters_private", "report-flakes_private"}; !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Actual: %v ; Expected: %v", names, expectedNames)
+	}
+}
+
+func TestGeneratorCollisionAcrossRuns(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	in := `presubmits:
+  istio/istio:
+  - name: unit
+    branches:
+    - master
+    spec:
+      containers:
+      - image: gcr.io/istio-testing/build-tools:latest
+`
+	for _, name := range []string{"first.yaml", "second.yaml"} {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(in), 0644); err != nil {
+			t.Fatalf("failed writing input file: %v", err)
+		}
+	}
+
+	out := filepath.Join(tmpDir, "out", "out.yaml")
+
+	generate := func(input string, prune bool) error {
+		o := newTestOptions(t, Transform{
+			OrgMap: map[string]string{"istio": "istio-private"},
+			Input:  filepath.Join(tmpDir, input),
+			Output: out,
+			Prune:  prune,
+		})
+		return NewGenerator(false).Generate(o)
+	}
+
+	// Without their source recorded, the jobs of a previous run are replaced.
+	if err := generate("first.yaml", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := generate("second.yaml", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := generate("first.yaml", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed reading output file: %v", err)
+	}
+
+	err = generate("second.yaml", true)
+	if err == nil || !strings.Contains(err.Error(), `job "unit_private" generated from second.yaml collides with existing job generated from first.yaml`) {
+		t.Fatalf("expected a collision error, got: %v", err)
+	}
+
+	if actual, err := ioutil.ReadFile(out); err != nil || string(actual) != string(expected) {
+		t.Errorf("expected output file to be left untouched, got: %s (%v)", actual, err)
+	}
+}
+
+func TestGeneratorTruncated(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	long := "integ-" + strings.Repeat("a", maxLabelLen)
+	in := fmt.Sprintf(`presubmits:
+  istio/istio:
+  - name: %s
+    spec:
+      containers:
+      - image: gcr.io/istio-testing/build-tools:latest
+  - name: unit
+    spec:
+      containers:
+      - image: gcr.io/istio-testing/build-tools:latest
+`, long)
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "in.yaml"), []byte(in), 0644); err != nil {
+		t.Fatalf("failed writing input file: %v", err)
+	}
+
+	out := filepath.Join(tmpDir, "out.yaml")
+	o := newTestOptions(t, Transform{
+		OrgMap: map[string]string{"istio": "istio-private"},
+		Input:  filepath.Join(tmpDir, "in.yaml"),
+		Output: out,
+	})
+
+	g := NewGenerator(true)
+
+	if err := g.Generate(o); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	name := truncateName(long, maxLabelLen-len("_private")) + "_private"
+	expected := []Truncated{{Type: "presubmit", OrgRepo: "istio-private/istio", Original: long, Name: name, Output: out}}
+	if actual := g.Truncated(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Actual: %v ; Expected: %v", actual, expected)
+	}
+
+	var buf bytes.Buffer
+	ReportTruncated(&buf, g.Truncated())
+
+	if report := fmt.Sprintf("truncate presubmit of istio-private/istio name %s to %s in %s\n", long, name, out); buf.String() != report {
+		t.Errorf("Actual: %q ; Expected: %q", buf.String(), report)
+	}
+}
+
+func TestGeneratorTruncatedCollision(t *testing.T) {
+	long := "integ-" + strings.Repeat("a", maxLabelLen)
+	// short is generated with the same name as long once long is truncated.
+	short := truncateName(long, maxLabelLen-len("_private"))
+
+	presubmits := fmt.Sprintf(`presubmits:
+  istio/istio:
+  - name: %s
+    spec:
+      containers:
+      - image: gcr.io/istio-testing/build-tools:latest
+  - name: %s
+    spec:
+      containers:
+      - image: gcr.io/istio-testing/build-tools:latest
+`, long, short)
+	periodic := `periodics:
+- name: %s
+  interval: 1h
+  spec:
+    containers:
+    - image: gcr.io/istio-testing/build-tools:latest
+`
+
+	tests := []struct {
+		name   string
+		inputs map[string]string
+		opts   func(dir string) []Transform
+		err    string
+	}{
+		{
+			name:   "transforms of the same input",
+			inputs: map[string]string{"in.yaml": presubmits},
+			opts: func(dir string) []Transform {
+				var transforms []Transform
+				for _, job := range []string{long, short} {
+					transforms = append(transforms, Transform{
+						OrgMap:       map[string]string{"istio": "istio-private"},
+						Input:        filepath.Join(dir, "in.yaml"),
+						Output:       filepath.Join(dir, "out.yaml"),
+						JobWhitelist: []string{"^" + regexp.QuoteMeta(job) + "$"},
+					})
+				}
+				return transforms
+			},
+			err: fmt.Sprintf("is generated from both %q and %q", long, short),
+		},
+		{
+			name: "periodics assigned to a repo",
+			inputs: map[string]string{
+				"istio/istio/periodics.yaml": fmt.Sprintf(periodic, long),
+				"istio/proxy/periodics.yaml": fmt.Sprintf(periodic, short),
+			},
+			opts: func(dir string) []Transform {
+				return []Transform{{
+					OrgMap:       map[string]string{"istio": "istio-private"},
+					Input:        dir,
+					Output:       filepath.Join(dir, "out"),
+					PeriodicRepo: "istio/test-infra",
+				}}
+			},
+			err: "is generated from both",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatalf("failed creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			for name, content := range test.inputs {
+				p := filepath.Join(tmpDir, name)
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatalf("failed creating input dir: %v", err)
+				}
+				if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+					t.Fatalf("failed writing input file: %v", err)
+				}
+			}
+
+			var optsList []Options
+			for _, tr := range test.opts(tmpDir) {
+				optsList = append(optsList, newTestOptions(t, tr))
+			}
+
+			if err := NewGenerator(true).Generate(optsList...); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got: %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestGeneratorWriteError(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The output directory can not be created, since a file has the same path.
+	file := filepath.Join(tmpDir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("failed writing file: %v", err)
+	}
+
+	o := newTestOptions(t, Transform{
+		OrgMap: map[string]string{"istio": "istio-private"},
+		Input:  "../../testdata/simple_transform/simple_transform_in.yaml",
+		Output: filepath.Join(file, "out.yaml"),
+	})
+
+	if err := NewGenerator(false).Generate(o); err == nil || !strings.Contains(err.Error(), "unable to write jobs to path") {
+		t.Errorf("expected a write error, got: %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/test-infra/prow/config"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/test-infra/prow/genjobs/pkg/util"
+)
+
+// ImageMapping is a rewrite rule for container image references.
+type ImageMapping struct {
+	// Prefix is the registry or repository prefix to replace (e.g. gcr.io/istio-testing).
+	Prefix string `json:"prefix,omitempty"`
+	// Regex is the regular expression to replace, as an alternative to Prefix.
+	Regex string `json:"regex,omitempty"`
+	// Replace is the replacement for the prefix or regular expression (supports $1 expansion for Regex).
+	Replace string `json:"replace,omitempty"`
+	// Tag optionally pins the tag of rewritten container images.
+	Tag string `json:"tag,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// compileImageMappings validates a list of image mappings and compiles their regular expressions.
+func compileImageMappings(mappings []ImageMapping) ([]ImageMapping, error) {
+	compiled := make([]ImageMapping, 0, len(mappings))
+
+	for i, m := range mappings {
+		switch {
+		case m.Prefix != "" && m.Regex != "":
+			return nil, fmt.Errorf("image mapping %d: only one of prefix or regex may be specified", i)
+		case m.Prefix != "":
+			m.Prefix = strings.TrimSuffix(m.Prefix, "/")
+			m.Replace = strings.TrimSuffix(m.Replace, "/")
+		case m.Regex != "":
+			re, err := regexp.Compile(m.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("image mapping %d: invalid regex %q: %v", i, m.Regex, err)
+			}
+			m.re = re
+		default:
+			return nil, fmt.Errorf("image mapping %d: one of prefix or regex is required", i)
+		}
+		compiled = append(compiled, m)
+	}
+
+	return compiled, nil
+}
+
+// imageMappingsFromMap converts a prefix to replacement map into image mappings, with the longest prefix first.
+func imageMappingsFromMap(m map[string]string) []ImageMapping {
+	var mappings []ImageMapping
+
+	for _, prefix := range util.SortedKeys(m) {
+		mappings = append(mappings, ImageMapping{Prefix: prefix, Replace: m[prefix]})
+	}
+
+	sort.SliceStable(mappings, func(a, b int) bool {
+		return len(mappings[a].Prefix) > len(mappings[b].Prefix)
+	})
+
+	return mappings
+}
+
+// readImageManifest reads a yaml file mapping image references to digests.
+func readImageManifest(p string) (map[string]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := map[string]string{}
+	if err := yaml.UnmarshalStrict(b, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid image manifest %v: %v", p, err)
+	}
+
+	return manifest, nil
+}
+
+// rewrite applies the mapping to a reference, returning false if the mapping does not match.
+func (m ImageMapping) rewrite(ref string) (string, bool) {
+	if m.re != nil {
+		if !m.re.MatchString(ref) {
+			return ref, false
+		}
+		return m.re.ReplaceAllString(ref, m.Replace), true
+	}
+
+	if ref == m.Prefix {
+		return m.Replace, true
+	}
+
+	rest := strings.TrimPrefix(ref, m.Prefix)
+	if rest == ref || !strings.ContainsAny(rest[:1], "/:@") {
+		return ref, false
+	}
+
+	return m.Replace + rest, true
+}
+
+// splitImage splits an image reference into its repository, tag, and digest.
+func splitImage(image string) (string, string, string) {
+	var repo, tag, digest string
+
+	repo = image
+	if i := strings.Index(repo, "@"); i >= 0 {
+		repo, digest = repo[:i], repo[i+1:]
+	}
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+
+	return repo, tag, digest
+}
+
+// mapImage rewrites a container image with the first matching mapping, pins its tag, and resolves its digest.
+func mapImage(o Options, image string) string {
+	for _, m := range o.ImageMapping {
+		mapped, ok := m.rewrite(image)
+		if !ok {
+			continue
+		}
+
+		image = mapped
+
+		if m.Tag != "" {
+			repo, _, _ := splitImage(image)
+			image = repo + ":" + m.Tag
+		}
+
+		break
+	}
+
+	if digest, ok := o.imageDigests[image]; ok {
+		repo, _, _ := splitImage(image)
+		image = repo + "@" + digest
+	}
+
+	return image
+}
+
+// mapEnvValue rewrites an env value which references an image with the first matching mapping.
+func mapEnvValue(o Options, value string) string {
+	for _, m := range o.ImageMapping {
+		if mapped, ok := m.rewrite(value); ok {
+			return mapped
+		}
+	}
+
+	return value
+}
+
+// updateImages updates the jobs container, init container, and env image references based on provided inputs.
+func updateImages(o Options, job *config.JobBase) {
+	if job.Spec == nil || (len(o.ImageMapping) == 0 && len(o.imageDigests) == 0) {
+		return
+	}
+
+	update := func(containers []v1.Container) {
+		for i := range containers {
+			containers[i].Image = mapImage(o, containers[i].Image)
+
+			for j := range containers[i].Env {
+				if containers[i].Env[j].Value != "" {
+					containers[i].Env[j].Value = mapEnvValue(o, containers[i].Env[j].Value)
+				}
+			}
+		}
+	}
+
+	update(job.Spec.InitContainers)
+	update(job.Spec.Containers)
+}
+
+// MapImages maps the container, init container, and env image references of the jobs.
+func MapImages(o Options, set *JobSet) error {
+	for _, job := range set.Jobs {
+		updateImages(o, job.Base())
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"k8s.io/test-infra/prow/config"
+	"sigs.k8s.io/yaml"
+)
+
+// input is an input file which is read and parsed at most once per run.
+type input struct {
+	once sync.Once
+	// content is the parsed input file encoded as json, which is cheaper to decode than yaml.
+	content []byte
+	hash    string
+	err     error
+}
+
+// inputs caches the input files read during a run, so that input files shared by several transforms are only read
+// and parsed once. It is safe for concurrent use.
+type inputs struct {
+	mu    sync.Mutex
+	files map[string]*input
+}
+
+// newInputs creates an empty input cache.
+func newInputs() *inputs {
+	return &inputs{files: map[string]*input{}}
+}
+
+// read returns the jobs of an input file and the hash of its content. Every call decodes a new copy of the jobs, which
+// the caller is free to modify.
+func (in *inputs) read(p string) (config.JobConfig, string, error) {
+	in.mu.Lock()
+	f, ok := in.files[p]
+	if !ok {
+		f = &input{}
+		in.files[p] = f
+	}
+	in.mu.Unlock()
+
+	f.once.Do(func() {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			f.err = err
+			return
+		}
+
+		var jobs config.JobConfig
+		if err := yaml.Unmarshal(b, &jobs); err != nil {
+			f.err = fmt.Errorf("error unmarshaling %s: %v", p, err)
+			return
+		}
+
+		f.hash = fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+		f.content, f.err = json.Marshal(jobs)
+	})
+
+	var jobs config.JobConfig
+
+	if f.err != nil {
+		return jobs, "", f.err
+	}
+
+	if err := json.Unmarshal(f.content, &jobs); err != nil {
+		return jobs, "", fmt.Errorf("error unmarshaling %s: %v", p, err)
+	}
+
+	return jobs, f.hash, nil
+}
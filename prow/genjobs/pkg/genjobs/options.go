@@ -0,0 +1,414 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	prowjob "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/prow/genjobs/pkg/util"
+)
+
+const (
+	autogenHeader        = "# THIS FILE IS AUTOGENERATED. DO NOT EDIT. See genjobs/README.md\n"
+	filenameSeparator    = "."
+	jobnameSeparator     = "_"
+	nameHashSeparator    = "-"
+	gitHost              = "github.com"
+	maxLabelLen          = 63
+	nameHashLen          = 8
+	defaultCluster       = "default"
+	defaultsFilename     = ".defaults.yaml"
+	yamlExt              = ".(yml|yaml)$"
+	sourceAnnotation     = "genjobs.istio.io/source"
+	sourceJobAnnotation  = "genjobs.istio.io/source-job"
+	sourceHashAnnotation = "genjobs.istio.io/source-hash"
+	transformAnnotation  = "genjobs.istio.io/transform"
+)
+
+const (
+	// DefaultModifier is the default modifier applied to generated file and job names.
+	DefaultModifier = "private"
+)
+
+var (
+	// DefaultJobTypes are the job types processed by default.
+	DefaultJobTypes = []string{"presubmit", "postsubmit", "periodic"}
+)
+
+// sortOrder is the type to define sort order.
+type sortOrder string
+
+const (
+	ascending  sortOrder = "asc"
+	descending sortOrder = "desc"
+)
+
+// Configuration is the yaml configuration file format.
+type Configuration struct {
+	Defaults   Transform   `json:"defaults,omitempty"`
+	Transforms []Transform `json:"transforms,omitempty"`
+}
+
+// Transform are the available transformation fields.
+type Transform struct {
+	Annotations      map[string]string `json:"annotations,omitempty"`
+	Bucket           string            `json:"bucket,omitempty"`
+	Cluster          string            `json:"cluster,omitempty"`
+	Channel          string            `json:"channel,omitempty"`
+	SSHKeySecret     string            `json:"ssh-key-secret,omitempty"`
+	Modifier         string            `json:"modifier,omitempty"`
+	ImageManifest    string            `json:"image-manifest,omitempty"`
+	Input            string            `json:"input,omitempty"`
+	Output           string            `json:"output,omitempty"`
+	PeriodicRepo     string            `json:"periodic-repo,omitempty"`
+	Sort             string            `json:"sort,omitempty"`
+	ExtraRefs        []prowjob.Refs    `json:"extra-refs,omitempty"`
+	Branches         []string          `json:"branches,omitempty"`
+	BranchesOut      []string          `json:"branches-out,omitempty"`
+	Presets          []string          `json:"presets,omitempty"`
+	RerunOrgs        []string          `json:"rerun-orgs,omitempty"`
+	RerunUsers       []string          `json:"rerun-users,omitempty"`
+	EnvBlacklist     []string          `json:"env-blacklist,omitempty"`
+	VolumeBlacklist  []string          `json:"volume-blacklist,omitempty"`
+	JobWhitelist     []string          `json:"job-whitelist,omitempty"`
+	JobBlacklist     []string          `json:"job-blacklist,omitempty"`
+	RepoWhitelist    []string          `json:"repo-whitelist,omitempty"`
+	RepoBlacklist    []string          `json:"repo-blacklist,omitempty"`
+	JobType          []string          `json:"job-type,omitempty"`
+	Include          []string          `json:"include,omitempty"`
+	Exclude          []string          `json:"exclude,omitempty"`
+	Selector         map[string]string `json:"selector,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Env              map[string]string `json:"env,omitempty"`
+	OrgMap           map[string]string `json:"mapping,omitempty"`
+	BranchMap        map[string]string `json:"branch-mapping,omitempty"`
+	VolumeMap        map[string]string `json:"volume-mapping,omitempty"`
+	Patches          []Patch           `json:"patches,omitempty"`
+	ImageMapping     []ImageMapping    `json:"image-mapping,omitempty"`
+	Clean            bool              `json:"clean,omitempty"`
+	DryRun           bool              `json:"dry-run,omitempty"`
+	Prune            bool              `json:"prune,omitempty"`
+	Provenance       bool              `json:"provenance,omitempty"`
+	Refs             bool              `json:"refs,omitempty"`
+	Resolve          bool              `json:"resolve,omitempty"`
+	StripPresets     bool              `json:"strip-presets,omitempty"`
+	SSHClone         bool              `json:"ssh-clone,omitempty"`
+	OverrideSelector bool              `json:"override-selector,omitempty"`
+	Verbose          bool              `json:"verbose,omitempty"`
+}
+
+// Options are the available generation options, which are either command-line flags or a configuration transform.
+// ConfigPath and ConfigIndex record the configuration file, and index within it, a transform was loaded from, and
+// ConfigName records that file relative to its --configs entry. Options must be validated before generating jobs.
+type Options struct {
+	Configs            []string
+	Global             string
+	Check              bool
+	Diff               bool
+	Report             bool
+	ValidateConfig     bool
+	Parallelism        int
+	ConfigPath         string
+	ConfigIndex        int
+	ConfigName         string
+	ImageMap           map[string]string
+	imageDigests       map[string]string
+	envBlacklistSet    sets.String
+	volumeBlacklistSet sets.String
+	jobWhitelistSet    util.PatternSet
+	jobBlacklistSet    util.PatternSet
+	repoWhitelistSet   util.PatternSet
+	repoBlacklistSet   util.PatternSet
+	jobTypeSet         sets.String
+	includeSelectors   []selector
+	excludeSelectors   []selector
+	compiledPatches    []compiledPatch
+	branchMappings     []branchMapping
+	presets            []config.Preset
+	Transform
+}
+
+// Validate validates the options and compiles the sets, patterns, selectors, mappings and patches they define.
+func (o *Options) Validate() error {
+	var err error
+
+	o.envBlacklistSet = sets.NewString(o.EnvBlacklist...)
+	o.volumeBlacklistSet = sets.NewString(o.VolumeBlacklist...)
+	o.jobTypeSet = sets.NewString(o.JobType...)
+	o.ImageMapping = append(imageMappingsFromMap(o.ImageMap), o.ImageMapping...)
+
+	for i, c := range o.Configs {
+		if o.Configs[i], err = filepath.Abs(c); err != nil {
+			return &util.ExitError{Message: fmt.Sprintf("--configs option invalid: %v.", o.Configs[i]), Code: 1}
+		} else if !util.Exists(o.Configs[i]) {
+			return &util.ExitError{Message: fmt.Sprintf("--configs option path does not exist: %v.", o.Configs[i]), Code: 1}
+		} else if util.IsFile(o.Configs[i]) && !util.HasExtension(o.Configs[i], yamlExt) {
+			return &util.ExitError{Message: fmt.Sprintf("--configs option path is not a yaml file: %v.", o.Configs[i]), Code: 1}
+		}
+	}
+
+	if o.Global != "" {
+		if o.Global, err = filepath.Abs(o.Global); err != nil {
+			return &util.ExitError{Message: fmt.Sprintf("--global option invalid: %v.", o.Global), Code: 1}
+		} else if !util.Exists(o.Global) {
+			return &util.ExitError{Message: fmt.Sprintf("--global option path does not exist: %v.", o.Global), Code: 1}
+		} else if util.IsFile(o.Global) && !util.HasExtension(o.Global, yamlExt) {
+			return &util.ExitError{Message: fmt.Sprintf("--global option path is not a yaml file: %v.", o.Global), Code: 1}
+		}
+	}
+
+	if o.jobWhitelistSet, err = util.NewPatternSet(o.JobWhitelist...); err != nil {
+		return &util.ExitError{Message: fmt.Sprintf("--job-whitelist option invalid: %v.", err), Code: 1}
+	}
+
+	if o.jobBlacklistSet, err = util.NewPatternSet(o.JobBlacklist...); err != nil {
+		return &util.ExitError{Message: fmt.Sprintf("--job-blacklist option invalid: %v.", err), Code: 1}
+	}
+
+	if o.repoWhitelistSet, err = util.NewPatternSet(o.RepoWhitelist...); err != nil {
+		return &util.ExitError{Message: fmt.Sprintf("-w, --repo-whitelist option invalid: %v.", err), Code: 1}
+	}
+
+	if o.repoBlacklistSet, err = util.NewPatternSet(o.RepoBlacklist...); err != nil {
+		return &util.ExitError{Message: fmt.Sprintf("-b, --repo-blacklist option invalid: %v.", err), Code: 1}
+	}
+
+	if o.includeSelectors, err = parseSelectors(o.Include); err != nil {
+		return &util.ExitError{Message: fmt.Sprintf("--include option invalid: %v.", err), Code: 1}
+	}
+
+	if o.excludeSelectors, err = parseSelectors(o.Exclude); err != nil {
+		return &util.ExitError{Message: fmt.Sprintf("--exclude option invalid: %v.", err), Code: 1}
+	}
+
+	if o.branchMappings, err = compileBranchMappings(o.BranchMap); err != nil {
+		return &util.ExitError{Message: fmt.Sprintf("--branch-mapping option invalid: %v.", err), Code: 1}
+	}
+
+	if o.ImageMapping, err = compileImageMappings(o.ImageMapping); err != nil {
+		return &util.ExitError{Message: fmt.Sprintf("--image-mapping option invalid: %v.", err), Code: 1}
+	}
+
+	if o.ImageManifest != "" {
+		if o.ImageManifest, err = filepath.Abs(o.ImageManifest); err != nil {
+			return &util.ExitError{Message: fmt.Sprintf("--image-manifest option invalid: %v.", o.ImageManifest), Code: 1}
+		} else if !util.Exists(o.ImageManifest) {
+			return &util.ExitError{Message: fmt.Sprintf("--image-manifest option path does not exist: %v.", o.ImageManifest), Code: 1}
+		} else if o.imageDigests, err = readImageManifest(o.ImageManifest); err != nil {
+			return &util.ExitError{Message: fmt.Sprintf("--image-manifest option invalid: %v.", err), Code: 1}
+		}
+	}
+
+	if o.compiledPatches, err = compilePatches(o.Patches); err != nil {
+		return &util.ExitError{Message: fmt.Sprintf("patches option invalid: %v.", err), Code: 1}
+	}
+
+	if o.StripPresets && !o.Resolve {
+		return &util.ExitError{Message: "--strip-presets option requires --resolve.", Code: 1}
+	}
+
+	if o.PeriodicRepo != "" {
+		if org, repo := util.SplitOrgRepo(o.PeriodicRepo); org == "" || repo == "" {
+			return &util.ExitError{Message: fmt.Sprintf("--periodic-repo option must be of the form org/repo: %v.", o.PeriodicRepo), Code: 1}
+		}
+	}
+
+	if o.Parallelism < 0 {
+		return &util.ExitError{Message: "--parallelism option must not be negative.", Code: 1}
+	}
+
+	if o.ValidateConfig && len(o.Configs) == 0 {
+		return &util.ExitError{Message: "--validate-config option requires --configs.", Code: 1}
+	}
+
+	if len(o.Configs) == 0 {
+		if len(o.OrgMap) == 0 {
+			return &util.ExitError{Message: "-m, --mapping option is required.", Code: 1}
+		}
+
+		if o.Input, err = filepath.Abs(o.Input); err != nil {
+			return &util.ExitError{Message: fmt.Sprintf("-i, --input option invalid: %v.", o.Input), Code: 1}
+		}
+
+		if o.Output, err = filepath.Abs(o.Output); err != nil {
+			return &util.ExitError{Message: fmt.Sprintf("-o, --output option invalid: %v.", o.Output), Code: 1}
+		}
+
+		for i, c := range o.Presets {
+			if o.Presets[i], err = filepath.Abs(c); err != nil {
+				return &util.ExitError{Message: fmt.Sprintf("-p, --preset option invalid: %v.", o.Presets[i]), Code: 1}
+			} else if !util.Exists(o.Presets[i]) {
+				return &util.ExitError{Message: fmt.Sprintf("-p, --preset option path does not exist: %v.", o.Presets[i]), Code: 1}
+			} else if util.IsFile(o.Presets[i]) && !util.HasExtension(o.Presets[i], yamlExt) {
+				return &util.ExitError{Message: fmt.Sprintf("-p, --preset option path is not a yaml file: %v.", o.Presets[i]), Code: 1}
+			}
+		}
+	}
+
+	o.presets = combinePresets(o.Presets)
+
+	return nil
+}
+
+// applyDefaultTransforms defaults transform struct from left to right with decreasing precedence.
+func applyDefaultTransforms(dst *Transform, srcs ...*Transform) {
+	for _, src := range srcs {
+		if dst.Annotations == nil {
+			dst.Annotations = src.Annotations
+		}
+		if dst.Bucket == "" {
+			dst.Bucket = src.Bucket
+		}
+		if dst.Cluster == "" {
+			dst.Cluster = src.Cluster
+		}
+		if dst.Channel == "" {
+			dst.Channel = src.Channel
+		}
+		if dst.SSHKeySecret == "" {
+			dst.SSHKeySecret = src.SSHKeySecret
+		}
+		if dst.Modifier == "" {
+			dst.Modifier = src.Modifier
+		}
+		if dst.ImageManifest == "" {
+			dst.ImageManifest = src.ImageManifest
+		}
+		if dst.Input == "" {
+			dst.Input = src.Input
+		}
+		if dst.Output == "" {
+			dst.Output = src.Output
+		}
+		if dst.PeriodicRepo == "" {
+			dst.PeriodicRepo = src.PeriodicRepo
+		}
+		if dst.Sort == "" {
+			dst.Sort = src.Sort
+		}
+		if len(dst.ExtraRefs) == 0 {
+			dst.ExtraRefs = src.ExtraRefs
+		}
+		if len(dst.Branches) == 0 {
+			dst.Branches = src.Branches
+		}
+		if len(dst.BranchesOut) == 0 {
+			dst.BranchesOut = src.BranchesOut
+		}
+		if len(dst.Presets) == 0 {
+			dst.Presets = src.Presets
+		}
+		if len(dst.RerunOrgs) == 0 {
+			dst.RerunOrgs = src.RerunOrgs
+		}
+		if len(dst.RerunUsers) == 0 {
+			dst.RerunUsers = src.RerunUsers
+		}
+		if len(dst.EnvBlacklist) == 0 {
+			dst.EnvBlacklist = src.EnvBlacklist
+		}
+		if len(dst.VolumeBlacklist) == 0 {
+			dst.VolumeBlacklist = src.VolumeBlacklist
+		}
+		if len(dst.JobWhitelist) == 0 {
+			dst.JobWhitelist = src.JobWhitelist
+		}
+		if len(dst.JobBlacklist) == 0 {
+			dst.JobBlacklist = src.JobBlacklist
+		}
+		if len(dst.RepoWhitelist) == 0 {
+			dst.RepoWhitelist = src.RepoWhitelist
+		}
+		if len(dst.RepoBlacklist) == 0 {
+			dst.RepoBlacklist = src.RepoBlacklist
+		}
+		if len(dst.JobType) == 0 {
+			dst.JobType = src.JobType
+		}
+		if len(dst.Include) == 0 {
+			dst.Include = src.Include
+		}
+		if len(dst.Exclude) == 0 {
+			dst.Exclude = src.Exclude
+		}
+		if len(dst.Selector) == 0 {
+			dst.Selector = src.Selector
+		}
+		if len(dst.Labels) == 0 {
+			dst.Labels = src.Labels
+		}
+		if len(dst.Env) == 0 {
+			dst.Env = src.Env
+		}
+		if len(dst.OrgMap) == 0 {
+			dst.OrgMap = src.OrgMap
+		}
+		if len(dst.BranchMap) == 0 {
+			dst.BranchMap = src.BranchMap
+		}
+		if len(dst.VolumeMap) == 0 {
+			dst.VolumeMap = src.VolumeMap
+		}
+		if len(dst.Patches) == 0 {
+			dst.Patches = src.Patches
+		}
+		if len(dst.ImageMapping) == 0 {
+			dst.ImageMapping = src.ImageMapping
+		}
+		if !dst.Clean {
+			dst.Clean = src.Clean
+		}
+		if !dst.DryRun {
+			dst.DryRun = src.DryRun
+		}
+		if !dst.Prune {
+			dst.Prune = src.Prune
+		}
+		if !dst.Provenance {
+			dst.Provenance = src.Provenance
+		}
+		if !dst.Refs {
+			dst.Refs = src.Refs
+		}
+		if !dst.Resolve {
+			dst.Resolve = src.Resolve
+		}
+		if !dst.StripPresets {
+			dst.StripPresets = src.StripPresets
+		}
+		if !dst.SSHClone {
+			dst.SSHClone = src.SSHClone
+		}
+		if !dst.OverrideSelector {
+			dst.OverrideSelector = src.OverrideSelector
+		}
+		if !dst.Verbose {
+			dst.Verbose = src.Verbose
+		}
+		if !dst.Clean {
+			dst.Clean = src.Clean
+		}
+		if !dst.Clean {
+			dst.Clean = src.Clean
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	diffContextLines = 3
+)
+
+// outputs holds the output files generated during a run. In memory mode, files are kept in memory rather than
+// written to disk so they can be compared against the files on disk.
+type outputs struct {
+	inMemory bool
+	// files maps an output path to its generated content; a nil content marks a removed file.
+	files map[string][]byte
+	// cleaned records the output files cleaned during the run.
+	cleaned map[string]bool
+}
+
+// newOutputs creates an empty set of outputs.
+func newOutputs(inMemory bool) *outputs {
+	return &outputs{inMemory: inMemory, files: map[string][]byte{}, cleaned: map[string]bool{}}
+}
+
+// read reads an output file, preferring content generated in memory during the run.
+func (out *outputs) read(p string) ([]byte, error) {
+	if out.inMemory {
+		if b, ok := out.files[p]; ok {
+			if b == nil {
+				return nil, os.ErrNotExist
+			}
+			return b, nil
+		}
+	}
+
+	return ioutil.ReadFile(p)
+}
+
+// exists checks if an output file exists.
+func (out *outputs) exists(p string) bool {
+	_, err := out.read(p)
+	return err == nil
+}
+
+// write writes an output file.
+func (out *outputs) write(p string, b []byte) error {
+	if out.inMemory {
+		out.files[p] = b
+		return nil
+	}
+
+	dir := filepath.Dir(p)
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create output directory %v: %v", dir, err)
+	}
+
+	return ioutil.WriteFile(p, b, 0644)
+}
+
+// remove deletes an output file and any children.
+func (out *outputs) remove(p string) error {
+	if out.inMemory {
+		out.files[p] = nil
+		return nil
+	}
+
+	return os.RemoveAll(p)
+}
+
+// clean deletes an output file and any children the first time it is cleaned during the run, so that jobs written to
+// it since are kept.
+func (out *outputs) clean(p string) error {
+	if out.cleaned[p] {
+		return nil
+	}
+	out.cleaned[p] = true
+
+	return out.remove(p)
+}
+
+// paths returns the sorted paths of the output files generated in memory.
+func (out *outputs) paths() []string {
+	paths := make([]string, 0, len(out.files))
+
+	for p := range out.files {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+// changed returns the paths of the output files generated in memory that differ from the files on disk.
+func (out *outputs) changed() []string {
+	var changed []string
+
+	for _, p := range out.paths() {
+		onDisk, err := ioutil.ReadFile(p)
+		if err != nil && out.files[p] == nil {
+			continue
+		}
+		if err != nil || !bytes.Equal(onDisk, out.files[p]) {
+			changed = append(changed, p)
+		}
+	}
+
+	return changed
+}
+
+// diff writes a unified diff between the files on disk and the output files generated in memory.
+func (out *outputs) diff(w io.Writer) error {
+	for _, p := range out.changed() {
+		onDisk, _ := ioutil.ReadFile(p)
+
+		if err := difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+			A:        splitLines(string(onDisk)),
+			B:        splitLines(string(out.files[p])),
+			FromFile: p,
+			ToFile:   p,
+			Context:  diffContextLines,
+		}); err != nil {
+			return fmt.Errorf("unable to diff path %v: %v", p, err)
+		}
+	}
+
+	return nil
+}
+
+// splitLines splits a file into lines ending with a newline, without the empty line difflib.SplitLines adds after
+// the last line, so that added and removed files are diffed against no lines at all.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+
+	return lines
+}
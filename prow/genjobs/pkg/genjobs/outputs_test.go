@@ -0,0 +1,103 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputsChanged(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	onDisk := map[string]string{
+		"unchanged.yaml": "a\nb\n",
+		"modified.yaml":  "a\nb\nc\n",
+		"removed.yaml":   "a\n",
+	}
+	for name, content := range onDisk {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed writing file: %v", err)
+		}
+	}
+
+	path := func(name string) string {
+		return filepath.Join(tmpDir, name)
+	}
+
+	out := newOutputs(true)
+	for name, content := range map[string]string{
+		"unchanged.yaml": "a\nb\n",
+		"modified.yaml":  "a\nB\nc\n",
+		"added.yaml":     "a\n",
+	} {
+		if err := out.write(path(name), []byte(content)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, name := range []string{"removed.yaml", "missing.yaml"} {
+		if err := out.remove(path(name)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// A removed file which does not exist on disk is unchanged.
+	expected := []string{path("added.yaml"), path("modified.yaml"), path("removed.yaml")}
+	if actual := out.changed(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Actual: %v ; Expected: %v", actual, expected)
+	}
+
+	var buf bytes.Buffer
+	if err := out.diff(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedDiff := `--- DIR/added.yaml
++++ DIR/added.yaml
+@@ -0,0 +1 @@
++a
+--- DIR/modified.yaml
++++ DIR/modified.yaml
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+--- DIR/removed.yaml
++++ DIR/removed.yaml
+@@ -1 +0,0 @@
+-a
+`
+	if actual := strings.Replace(buf.String(), tmpDir, "DIR", -1); actual != expectedDiff {
+		t.Errorf("expected diff:\n%s\nactual diff:\n%s", expectedDiff, actual)
+	}
+
+	for name, content := range onDisk {
+		if actual, err := ioutil.ReadFile(path(name)); err != nil || string(actual) != content {
+			t.Errorf("expected %v to be left untouched on disk, got %q (%v)", name, actual, err)
+		}
+	}
+}
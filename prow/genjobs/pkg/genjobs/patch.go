@@ -0,0 +1,207 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	"istio.io/test-infra/prow/genjobs/pkg/util"
+)
+
+// PatchType is the type to define the kind of patch applied to a job.
+type PatchType string
+
+const (
+	JSONPatchType      PatchType = "json"
+	StrategicPatchType PatchType = "strategic"
+)
+
+// Patch is a JSON patch or strategic-merge patch applied to each job matching its filters.
+type Patch struct {
+	Type          PatchType       `json:"type,omitempty"`
+	Patch         json.RawMessage `json:"patch,omitempty"`
+	JobWhitelist  []string        `json:"job-whitelist,omitempty"`
+	JobBlacklist  []string        `json:"job-blacklist,omitempty"`
+	RepoWhitelist []string        `json:"repo-whitelist,omitempty"`
+	RepoBlacklist []string        `json:"repo-blacklist,omitempty"`
+	JobType       []string        `json:"job-type,omitempty"`
+	Include       []string        `json:"include,omitempty"`
+	Exclude       []string        `json:"exclude,omitempty"`
+}
+
+// compiledPatch is a patch with its filters compiled.
+type compiledPatch struct {
+	Patch
+	jsonPatch        jsonpatch.Patch
+	jobWhitelistSet  util.PatternSet
+	jobBlacklistSet  util.PatternSet
+	repoWhitelistSet util.PatternSet
+	repoBlacklistSet util.PatternSet
+	jobTypeSet       sets.String
+	includeSelectors []selector
+	excludeSelectors []selector
+}
+
+// compilePatches validates a list of patches and compiles their filters.
+func compilePatches(patches []Patch) ([]compiledPatch, error) {
+	var compiled []compiledPatch
+
+	for i, p := range patches {
+		c, err := compilePatch(p)
+		if err != nil {
+			return nil, fmt.Errorf("patch %d: %v", i, err)
+		}
+		compiled = append(compiled, c)
+	}
+
+	return compiled, nil
+}
+
+// compilePatch validates a patch and compiles its filters.
+func compilePatch(p Patch) (compiledPatch, error) {
+	var err error
+
+	c := compiledPatch{Patch: p, jobTypeSet: sets.NewString(p.JobType...)}
+
+	if len(p.Patch) == 0 {
+		return c, fmt.Errorf("patch is empty")
+	}
+
+	switch p.Type {
+	case JSONPatchType:
+		if c.jsonPatch, err = jsonpatch.DecodePatch(p.Patch); err != nil {
+			return c, fmt.Errorf("invalid json patch: %v", err)
+		}
+	case StrategicPatchType, "":
+		var m map[string]interface{}
+		if err = json.Unmarshal(p.Patch, &m); err != nil {
+			return c, fmt.Errorf("invalid strategic-merge patch: %v", err)
+		}
+	default:
+		return c, fmt.Errorf("unknown patch type %q (e.g. %s, %s)", p.Type, JSONPatchType, StrategicPatchType)
+	}
+
+	if c.jobWhitelistSet, err = util.NewPatternSet(p.JobWhitelist...); err != nil {
+		return c, err
+	}
+	if c.jobBlacklistSet, err = util.NewPatternSet(p.JobBlacklist...); err != nil {
+		return c, err
+	}
+	if c.repoWhitelistSet, err = util.NewPatternSet(p.RepoWhitelist...); err != nil {
+		return c, err
+	}
+	if c.repoBlacklistSet, err = util.NewPatternSet(p.RepoBlacklist...); err != nil {
+		return c, err
+	}
+	if c.includeSelectors, err = parseSelectors(p.Include); err != nil {
+		return c, err
+	}
+	if c.excludeSelectors, err = parseSelectors(p.Exclude); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// matches checks if a job passes the patch filters. A job passes the repo filters if any of its repos do.
+func (p compiledPatch) matches(name string, repos []string, jType string, props jobProperties) bool {
+	if p.jobBlacklistSet.Has(name) || (len(p.jobWhitelistSet) > 0 && !p.jobWhitelistSet.Has(name)) {
+		return false
+	}
+
+	if len(p.jobTypeSet) > 0 && !p.jobTypeSet.Has(jType) {
+		return false
+	}
+
+	if len(p.repoWhitelistSet) > 0 || len(p.repoBlacklistSet) > 0 {
+		matched := false
+		for _, repo := range repos {
+			if !p.repoBlacklistSet.Has(repo) && (len(p.repoWhitelistSet) == 0 || p.repoWhitelistSet.Has(repo)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	ok, _ := isMatchSelectors(p.includeSelectors, p.excludeSelectors, props)
+
+	return ok
+}
+
+// matchingPatches returns the patches whose filters a job passes, in the order they are defined.
+func matchingPatches(o Options, name string, repos []string, jType string, props jobProperties) []compiledPatch {
+	var patches []compiledPatch
+
+	for _, p := range o.compiledPatches {
+		if p.matches(name, repos, jType, props) {
+			patches = append(patches, p)
+		}
+	}
+
+	return patches
+}
+
+// applyPatches applies patches to a job, which must be a pointer to a Presubmit, Postsubmit, or Periodic.
+func applyPatches(patches []compiledPatch, job interface{}) error {
+	if len(patches) == 0 {
+		return nil
+	}
+
+	doc, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(job).Elem()
+
+	for _, p := range patches {
+		switch p.Type {
+		case JSONPatchType:
+			doc, err = p.jsonPatch.Apply(doc)
+		default:
+			doc, err = strategicpatch.StrategicMergePatch(doc, p.Patch.Patch, v.Interface())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The job is reset so that fields removed by a patch are not retained.
+	v.Set(reflect.Zero(v.Type()))
+
+	return json.Unmarshal(doc, job)
+}
+
+// PatchJobs applies the patches whose filters each original job passed.
+func PatchJobs(o Options, set *JobSet) error {
+	for _, job := range set.Jobs {
+		if err := applyPatches(job.patches, job.object()); err != nil {
+			return fmt.Errorf("unable to patch %s %q: %v", job.Type(), job.Base().Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/config"
+)
+
+// pruneJobBase prunes blacklisted fields from the job Spec.
+func pruneJobBase(o Options, job *config.JobBase) {
+	if job.Spec != nil {
+		if len(o.volumeBlacklistSet) > 0 {
+			pruneVolumes(o.volumeBlacklistSet, job)
+		}
+		if len(o.envBlacklistSet) > 0 {
+			pruneEnvs(o.envBlacklistSet, job)
+		}
+	}
+}
+
+// pruneEnvs prunes blacklisted Env fields.
+func pruneEnvs(blacklist sets.String, job *config.JobBase) {
+	for i := range job.Spec.Containers {
+		var envs []v1.EnvVar
+
+		for _, env := range job.Spec.Containers[i].Env {
+			if blacklist.Has(env.Name) {
+				continue
+			}
+			envs = append(envs, env)
+		}
+		job.Spec.Containers[i].Env = envs
+	}
+}
+
+// pruneVolumes prunes blacklisted Volume and VolueMount fields.
+func pruneVolumes(blacklist sets.String, job *config.JobBase) {
+	var volumes []v1.Volume
+
+	for _, vol := range job.Spec.Volumes {
+		if blacklist.Has(vol.Name) {
+			continue
+		}
+		volumes = append(volumes, vol)
+	}
+	job.Spec.Volumes = volumes
+
+	for i := range job.Spec.Containers {
+		var volumeMounts []v1.VolumeMount
+
+		for _, volm := range job.Spec.Containers[i].VolumeMounts {
+			if blacklist.Has(volm.Name) {
+				continue
+			}
+			volumeMounts = append(volumeMounts, volm)
+		}
+		job.Spec.Containers[i].VolumeMounts = volumeMounts
+	}
+}
+
+// PruneJobs prunes blacklisted fields from the jobs.
+func PruneJobs(o Options, set *JobSet) error {
+	for _, job := range set.Jobs {
+		pruneJobBase(o, job.Base())
+	}
+
+	return nil
+}
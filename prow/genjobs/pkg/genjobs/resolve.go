@@ -0,0 +1,172 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/test-infra/prow/config"
+)
+
+// combinePresets reads a list of paths and aggregates the presets.
+func combinePresets(paths []string) []config.Preset {
+	presets := []config.Preset{}
+
+	if len(paths) == 0 {
+		return presets
+	}
+
+	for _, p := range paths {
+		c, err := config.ReadJobConfig(p)
+		if err != nil {
+			continue
+		}
+		presets = append(presets, c.Presets...)
+	}
+
+	return presets
+}
+
+// mergePreset merges a preset into a job Spec based on defined labels, returning whether the preset applied. As in
+// Prow, an env, volume or volume mount already defined in the job Spec is a conflict rather than being overwritten.
+func mergePreset(labels map[string]string, spec *v1.PodSpec, preset config.Preset) (bool, error) {
+	for l, v := range preset.Labels {
+		if v2, exists := labels[l]; !exists || v != v2 {
+			return false, nil
+		}
+	}
+
+	for _, env := range preset.Env {
+		for i := range spec.Containers {
+			for _, e := range spec.Containers[i].Env {
+				if e.Name == env.Name {
+					return true, fmt.Errorf("env var duplicated in pod spec: %s", env.Name)
+				}
+			}
+			spec.Containers[i].Env = append(spec.Containers[i].Env, env)
+		}
+	}
+
+	for _, vol := range preset.Volumes {
+		for _, v := range spec.Volumes {
+			if v.Name == vol.Name {
+				return true, fmt.Errorf("volume duplicated in pod spec: %s", vol.Name)
+			}
+		}
+		spec.Volumes = append(spec.Volumes, vol)
+	}
+
+	for _, volm := range preset.VolumeMounts {
+		for i := range spec.Containers {
+			for _, vm := range spec.Containers[i].VolumeMounts {
+				if vm.Name == volm.Name {
+					return true, fmt.Errorf("volume mount duplicated in pod spec: %s", volm.Name)
+				}
+			}
+			spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, volm)
+		}
+	}
+
+	return true, nil
+}
+
+// resolvePresets resolves all preset for a particular job Spec based on defined labels. Presets are always merged to
+// detect conflicts, but the job Spec is only updated when resolving.
+func resolvePresets(o Options, labels map[string]string, job *config.JobBase, presets []config.Preset) error {
+	if job.Spec == nil {
+		return nil
+	}
+
+	var conflicts []string
+	var resolved []config.Preset
+
+	spec := job.Spec.DeepCopy()
+
+	for _, preset := range presets {
+		merged, err := mergePreset(labels, spec, preset)
+		if err != nil {
+			conflicts = append(conflicts, err.Error())
+			continue
+		}
+		if merged {
+			resolved = append(resolved, preset)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("failed to merge presets for podspec: %v", strings.Join(conflicts, ", "))
+	}
+
+	if !o.Resolve {
+		return nil
+	}
+
+	job.Spec = spec
+
+	if o.StripPresets {
+		stripPresetLabels(job, resolved)
+	}
+
+	return nil
+}
+
+// stripPresetLabels removes the preset labels of resolved presets so they are not applied again.
+func stripPresetLabels(job *config.JobBase, presets []config.Preset) {
+	labels := make(map[string]string, len(job.Labels))
+	for k, v := range job.Labels {
+		labels[k] = v
+	}
+
+	for _, preset := range presets {
+		for l := range preset.Labels {
+			if strings.HasPrefix(l, presetLabelPrefix) {
+				delete(labels, l)
+			}
+		}
+	}
+
+	if len(labels) == 0 {
+		labels = nil
+	}
+
+	job.Labels = labels
+}
+
+// ResolveJobs resolves the presets of the jobs, dropping any job whose presets conflict and returning a ConflictError.
+func ResolveJobs(o Options, set *JobSet) error {
+	var kept []*Job
+	var conflicts []string
+
+	for _, job := range set.Jobs {
+		base := job.Base()
+		if err := resolvePresets(o, base.Labels, base, set.Presets); err != nil {
+			conflicts = append(conflicts, fmt.Sprintf("%s %v: %v", job.Type(), base.Name, err))
+			continue
+		}
+		kept = append(kept, job)
+	}
+
+	set.Jobs = kept
+
+	if len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/test-infra/prow/config"
+)
+
+const (
+	presetLabelPrefix = "preset-"
+)
+
+// selectorField is the type to define the job property a selector is evaluated against.
+type selectorField string
+
+const (
+	labelField      selectorField = "label"
+	annotationField selectorField = "annotation"
+	presetField     selectorField = "preset"
+	clusterField    selectorField = "cluster"
+	alwaysRunField  selectorField = "always_run"
+	optionalField   selectorField = "optional"
+)
+
+// selectorOp is the type to define the comparison operator of a selector.
+type selectorOp string
+
+const (
+	existsOp    selectorOp = ""
+	equalsOp    selectorOp = "="
+	notEqualsOp selectorOp = "!="
+)
+
+// selector is a parsed include/exclude expression over job properties.
+//
+// Expressions take the form `<field>[:<key>][(=|!=)<value>]`, for example:
+//
+//	label:preset-service-account=true
+//	annotation:testgrid-dashboards!=istio_release-1.4
+//	preset:service-account
+//	cluster=default
+//	always_run=false
+//	optional
+type selector struct {
+	field selectorField
+	key   string
+	op    selectorOp
+	value string
+}
+
+// jobProperties are the job attributes that selectors are evaluated against.
+type jobProperties struct {
+	Labels      map[string]string
+	Annotations map[string]string
+	Cluster     string
+	AlwaysRun   bool
+	Optional    bool
+}
+
+// newJobProperties creates the selector properties from the jobs JobBase fields.
+func newJobProperties(job config.JobBase) jobProperties {
+	cluster := job.Cluster
+	if cluster == "" {
+		cluster = defaultCluster
+	}
+
+	return jobProperties{
+		Labels:      job.Labels,
+		Annotations: job.Annotations,
+		Cluster:     cluster,
+	}
+}
+
+// parseSelectors parses a list of selector expressions.
+func parseSelectors(exprs []string) ([]selector, error) {
+	var selectors []selector
+
+	for _, expr := range exprs {
+		sel, err := parseSelector(expr)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+	}
+
+	return selectors, nil
+}
+
+// parseSelector parses a single selector expression.
+func parseSelector(expr string) (selector, error) {
+	var sel selector
+
+	lhs := strings.TrimSpace(expr)
+
+	if i := strings.Index(lhs, string(notEqualsOp)); i >= 0 {
+		sel.op, sel.value, lhs = notEqualsOp, strings.TrimSpace(lhs[i+len(notEqualsOp):]), strings.TrimSpace(lhs[:i])
+	} else if i := strings.Index(lhs, string(equalsOp)); i >= 0 {
+		sel.op, sel.value, lhs = equalsOp, strings.TrimSpace(lhs[i+len(equalsOp):]), strings.TrimSpace(lhs[:i])
+	}
+
+	field := lhs
+	if i := strings.Index(lhs, ":"); i >= 0 {
+		field, sel.key = lhs[:i], lhs[i+1:]
+	}
+	sel.field = selectorField(field)
+
+	switch sel.field {
+	case labelField, annotationField, presetField:
+		if sel.key == "" {
+			return sel, fmt.Errorf("selector %q requires a key (e.g. %s:<key>)", expr, sel.field)
+		}
+		if sel.field == presetField && !strings.HasPrefix(sel.key, presetLabelPrefix) {
+			sel.key = presetLabelPrefix + sel.key
+		}
+	case clusterField:
+		if sel.key != "" || sel.op == existsOp {
+			return sel, fmt.Errorf("selector %q must be of the form %s(=|!=)<value>", expr, sel.field)
+		}
+	case alwaysRunField, optionalField:
+		if sel.key != "" {
+			return sel, fmt.Errorf("selector %q does not accept a key", expr)
+		}
+		if sel.op == existsOp {
+			sel.op, sel.value = equalsOp, "true"
+		}
+		if _, err := strconv.ParseBool(sel.value); err != nil {
+			return sel, fmt.Errorf("selector %q requires a boolean value", expr)
+		}
+	default:
+		return sel, fmt.Errorf("selector %q has unknown field %q", expr, field)
+	}
+
+	return sel, nil
+}
+
+// matches evaluates the selector against the job properties.
+func (s selector) matches(props jobProperties) bool {
+	var (
+		actual string
+		exists bool
+	)
+
+	switch s.field {
+	case labelField, presetField:
+		actual, exists = props.Labels[s.key]
+	case annotationField:
+		actual, exists = props.Annotations[s.key]
+	case clusterField:
+		actual, exists = props.Cluster, true
+	case alwaysRunField:
+		actual, exists = strconv.FormatBool(props.AlwaysRun), true
+	case optionalField:
+		actual, exists = strconv.FormatBool(props.Optional), true
+	}
+
+	switch s.op {
+	case equalsOp:
+		return exists && s.equals(actual)
+	case notEqualsOp:
+		return !exists || !s.equals(actual)
+	default:
+		return exists
+	}
+}
+
+// equals compares the selector value to an actual value, normalizing boolean fields.
+func (s selector) equals(actual string) bool {
+	switch s.field {
+	case alwaysRunField, optionalField:
+		want, _ := strconv.ParseBool(s.value)
+		return strconv.FormatBool(want) == actual
+	default:
+		return s.value == actual
+	}
+}
+
+// String returns the selector in its expression form.
+func (s selector) String() string {
+	lhs := string(s.field)
+	if s.key != "" {
+		lhs += ":" + s.key
+	}
+	return lhs + string(s.op) + s.value
+}
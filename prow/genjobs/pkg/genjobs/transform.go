@@ -0,0 +1,376 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	prowjob "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	"k8s.io/test-infra/prow/config"
+
+	"istio.io/test-infra/prow/genjobs/pkg/util"
+)
+
+// jobNameSuffix returns the suffix appended to the name of generated jobs.
+func jobNameSuffix(o Options) string {
+	if o.Modifier == "" {
+		return ""
+	}
+
+	return jobnameSeparator + o.Modifier
+}
+
+// updateJobName updates the jobs Name fields based on provided inputs.
+func updateJobName(o Options, job *config.JobBase) {
+	suffix := jobNameSuffix(o)
+
+	job.Name = truncateName(job.Name, maxLabelLen-len(suffix)) + suffix
+}
+
+// truncateName cuts short a name which exceeds the maximum length.
+func truncateName(name string, maxLen int) string {
+	if len(name) <= maxLen {
+		return name
+	}
+
+	return name[:maxLen]
+}
+
+// hashName shortens a name which exceeds the maximum length. The name is cut short and suffixed with a hash of the
+// full name, so that names sharing a long prefix remain distinct.
+func hashName(name string, maxLen int) string {
+	if len(name) <= maxLen {
+		return name
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:nameHashLen]
+
+	prefix := strings.TrimRight(name[:maxLen-nameHashLen-len(nameHashSeparator)], nameHashSeparator+jobnameSeparator)
+
+	return prefix + nameHashSeparator + hash
+}
+
+// jobNameKey returns the key under which the name of a job must be unique.
+func jobNameKey(job *Job) string {
+	return strings.Join([]string{job.Type(), job.OrgRepo, job.Base().Name}, "|")
+}
+
+// hashJobNames renames the truncated jobs of a job set which collide with another job of the same type and for the
+// same org/repo, using a hash of their original name. Truncated jobs which do not collide keep their cut short name,
+// so that existing job names are stable.
+func hashJobNames(o Options, set *JobSet) {
+	suffix := jobNameSuffix(o)
+	original := map[string]string{}
+	colliding := map[string]bool{}
+
+	for _, job := range set.Jobs {
+		key := jobNameKey(job)
+
+		if existing, ok := original[key]; ok && existing != job.Original {
+			colliding[key] = true
+		}
+		original[key] = job.Original
+	}
+
+	for _, job := range set.Jobs {
+		if colliding[jobNameKey(job)] && job.Base().Name != job.Original+suffix {
+			job.Base().Name = hashName(job.Original, maxLabelLen-len(suffix)) + suffix
+		}
+	}
+}
+
+// checkJobNames checks that no two jobs of a job set, which are of the same type and for the same org/repo, are
+// generated with the same name from different original names. Jobs of different job sets written to the same output
+// file are checked when they are written.
+func checkJobNames(set *JobSet) error {
+	original := map[string]string{}
+
+	for _, job := range set.Jobs {
+		key := jobNameKey(job)
+
+		if existing, ok := original[key]; ok && existing != job.Original {
+			return fmt.Errorf("%s name %q is generated from both %q and %q", job.Type(), job.Base().Name, existing, job.Original)
+		}
+		original[key] = job.Original
+	}
+
+	return nil
+}
+
+// updateBrancher updates the jobs Brancher fields based on provided inputs.
+func updateBrancher(o Options, job *config.Brancher) {
+	updateBranches(o, job)
+
+	if len(o.BranchesOut) == 0 {
+		return
+	}
+
+	job.Branches = o.BranchesOut
+}
+
+// updateUtilityConfig updates the jobs UtilityConfig fields based on provided inputs.
+func updateUtilityConfig(o Options, job *config.UtilityConfig) {
+	if o.Bucket == "" && o.SSHKeySecret == "" {
+		return
+	}
+
+	if job.DecorationConfig == nil {
+		job.DecorationConfig = &prowjob.DecorationConfig{}
+	}
+
+	updateGCSConfiguration(o, job.DecorationConfig)
+	updateSSHKeySecrets(o, job.DecorationConfig)
+}
+
+// updateGCSConfiguration updates the jobs GCSConfiguration fields based on provided inputs.
+func updateGCSConfiguration(o Options, job *prowjob.DecorationConfig) {
+	if o.Bucket == "" {
+		return
+	}
+
+	if job.GCSConfiguration == nil {
+		job.GCSConfiguration = &prowjob.GCSConfiguration{
+			Bucket: o.Bucket,
+		}
+	} else {
+		job.GCSConfiguration.Bucket = o.Bucket
+	}
+}
+
+// updateSSHKeySecrets updates the jobs SSHKeySecrets fields based on provided inputs.
+func updateSSHKeySecrets(o Options, job *prowjob.DecorationConfig) {
+	if o.SSHKeySecret == "" {
+		return
+	}
+
+	if job.SSHKeySecrets == nil {
+		job.SSHKeySecrets = []string{o.SSHKeySecret}
+	} else {
+		job.SSHKeySecrets = append(job.SSHKeySecrets, o.SSHKeySecret)
+	}
+}
+
+// updateReporterConfig updates the jobs ReporterConfig fields based on provided inputs.
+func updateReporterConfig(o Options, job *config.JobBase) {
+	if o.Channel == "" {
+		return
+	}
+
+	if job.ReporterConfig == nil {
+		job.ReporterConfig = &prowjob.ReporterConfig{}
+	}
+
+	job.ReporterConfig.Slack = &prowjob.SlackReporterConfig{Channel: o.Channel}
+}
+
+// updateRerunAuthConfig updates the jobs RerunAuthConfig fields based on provided inputs.
+func updateRerunAuthConfig(o Options, job *config.JobBase) {
+	if len(o.RerunOrgs) == 0 && len(o.RerunUsers) == 0 {
+		return
+	}
+
+	// The original job `RerunAuthConfig` is overwritten with the user-defined values.
+	job.RerunAuthConfig = &prowjob.RerunAuthConfig{
+		GitHubOrgs:  o.RerunOrgs,
+		GitHubUsers: o.RerunUsers,
+	}
+}
+
+// updateLabels updates the jobs Labels fields based on provided inputs.
+func updateLabels(o Options, job *config.JobBase) {
+	if len(o.Labels) == 0 {
+		return
+	}
+
+	if job.Labels == nil {
+		job.Labels = make(map[string]string)
+	}
+
+	for labelK, labelV := range o.Labels {
+		job.Labels[labelK] = labelV
+	}
+}
+
+// updateNodeSelector updates the jobs NodeSelector fields based on provided inputs.
+func updateNodeSelector(o Options, job *config.JobBase) {
+	if o.OverrideSelector {
+		job.Spec.NodeSelector = make(map[string]string)
+	}
+
+	if len(o.Selector) == 0 {
+		return
+	}
+
+	if job.Spec.NodeSelector == nil {
+		job.Spec.NodeSelector = make(map[string]string)
+	}
+
+	for selK, selV := range o.Selector {
+		job.Spec.NodeSelector[selK] = selV
+	}
+}
+
+// updateEnvs updates the jobs Env fields based on provided inputs.
+func updateEnvs(o Options, job *config.JobBase) {
+	if len(o.Env) == 0 {
+		return
+	}
+
+	envKs := util.SortedKeys(o.Env)
+
+	for _, envK := range envKs {
+	container:
+		for i := range job.Spec.Containers {
+
+			for j := range job.Spec.Containers[i].Env {
+				if job.Spec.Containers[i].Env[j].Name == envK {
+					job.Spec.Containers[i].Env[j].Value = o.Env[envK]
+					continue container
+				}
+			}
+
+			job.Spec.Containers[i].Env = append(job.Spec.Containers[i].Env, v1.EnvVar{Name: envK, Value: o.Env[envK]})
+		}
+	}
+}
+
+// updateJobBase updates the jobs JobBase fields based on provided inputs to work with private repositories.
+func updateJobBase(o Options, job *config.JobBase, orgrepo string) {
+	job.Annotations = o.Annotations
+
+	if o.SSHClone && orgrepo != "" {
+		job.CloneURI = fmt.Sprintf("git@%s:%s.git", gitHost, orgrepo)
+	}
+
+	if o.Cluster != "" && o.Cluster != defaultCluster {
+		job.Cluster = o.Cluster
+	}
+
+	updateJobName(o, job)
+	updateReporterConfig(o, job)
+	updateRerunAuthConfig(o, job)
+	updateLabels(o, job)
+	updateNodeSelector(o, job)
+	updateEnvs(o, job)
+}
+
+// updateSourceAnnotation records the input a job was generated from so that stale jobs can be pruned by later runs.
+func updateSourceAnnotation(o Options, job *config.JobBase, source string) {
+	if !o.Prune {
+		return
+	}
+
+	setAnnotations(job, map[string]string{sourceAnnotation: source})
+}
+
+// updateProvenanceAnnotations records the source file, source job name, transform file and input content hash a job
+// was generated from, so that generated jobs can be traced back to their upstream definition.
+func updateProvenanceAnnotations(o Options, job *config.JobBase, set *JobSet, name string) {
+	if !o.Provenance {
+		return
+	}
+
+	provenance := map[string]string{
+		sourceAnnotation:     set.Source,
+		sourceJobAnnotation:  name,
+		sourceHashAnnotation: set.Hash,
+	}
+	if o.ConfigName != "" {
+		provenance[transformAnnotation] = filepath.ToSlash(o.ConfigName)
+	}
+
+	setAnnotations(job, provenance)
+}
+
+// setAnnotations sets annotations on a job. The annotations map may be shared with the options, so a copy is made
+// before it is modified.
+func setAnnotations(job *config.JobBase, values map[string]string) {
+	annotations := make(map[string]string, len(job.Annotations)+len(values))
+	for k, v := range job.Annotations {
+		annotations[k] = v
+	}
+	for k, v := range values {
+		annotations[k] = v
+	}
+
+	job.Annotations = annotations
+}
+
+// updateExtraRefs updates the jobs ExtraRefs fields based on provided inputs to work with private repositories.
+func updateExtraRefs(o Options, job *config.UtilityConfig) {
+	for i, ref := range job.ExtraRefs {
+		org, repo := ref.Org, ref.Repo
+
+		if o.Refs || validateOrgRepo(o, org, repo) {
+			// Only transform known org mappings.
+			if newOrg, ok := o.OrgMap[org]; ok {
+				org = newOrg
+			}
+			job.ExtraRefs[i].Org = org
+			job.ExtraRefs[i].BaseRef, _ = mapBranch(o, ref.BaseRef)
+			if o.SSHClone {
+				job.ExtraRefs[i].CloneURI = fmt.Sprintf("git@%s:%s/%s.git", gitHost, org, repo)
+			}
+		}
+	}
+	if len(o.ExtraRefs) > 0 {
+		job.ExtraRefs = o.ExtraRefs
+	}
+}
+
+// TransformJobs updates the jobs to work with private repositories based on provided inputs.
+func TransformJobs(o Options, set *JobSet) error {
+	for _, job := range set.Jobs {
+		if job.OrgRepo != "" {
+			job.OrgRepo = convertOrgRepoStr(o, job.OrgRepo)
+		}
+
+		name := job.Base().Name
+		job.Original = name
+
+		updateExtraRefs(o, job.UtilityConfig())
+		updateJobBase(o, job.Base(), job.OrgRepo)
+		updateSourceAnnotation(o, job.Base(), set.Source)
+		updateProvenanceAnnotations(o, job.Base(), set, name)
+		if b := job.Brancher(); b != nil {
+			updateBrancher(o, b)
+		}
+		updateUtilityConfig(o, job.UtilityConfig())
+
+		if job.Periodic != nil && len(job.Periodic.ExtraRefs) == 0 && o.PeriodicRepo != "" {
+			job.Output = getRepoOutPath(o, o.PeriodicRepo, set.Input)
+		}
+	}
+
+	hashJobNames(o, set)
+
+	for _, job := range set.Jobs {
+		if job.Base().Name != job.Original+jobNameSuffix(o) {
+			output := set.Output
+			if job.Output != "" {
+				output = job.Output
+			}
+			set.Truncated = append(set.Truncated, Truncated{Type: job.Type(), OrgRepo: job.OrgRepo, Original: job.Original, Name: job.Base().Name, Output: output})
+		}
+	}
+
+	return checkJobNames(set)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/test-infra/prow/config"
+)
+
+// mapVolumeName returns the mapped name of a volume, secret, configmap or persistent volume claim.
+func mapVolumeName(o Options, name string) string {
+	if mapped, ok := o.VolumeMap[name]; ok {
+		return mapped
+	}
+
+	return name
+}
+
+// updateVolumes updates the jobs volume, volume mount, and env references to secrets, configmaps and persistent
+// volume claims based on provided inputs.
+func updateVolumes(o Options, job *config.JobBase) {
+	if job.Spec == nil || len(o.VolumeMap) == 0 {
+		return
+	}
+
+	// The spec is copied since resolved presets share references with other jobs.
+	job.Spec = job.Spec.DeepCopy()
+
+	for i := range job.Spec.Volumes {
+		updateVolume(o, &job.Spec.Volumes[i])
+	}
+
+	update := func(containers []v1.Container) {
+		for i := range containers {
+			updateContainerVolumes(o, &containers[i])
+		}
+	}
+
+	update(job.Spec.InitContainers)
+	update(job.Spec.Containers)
+}
+
+// MapVolumes maps the volume, volume mount, and env references of the jobs to secrets, configmaps and persistent
+// volume claims.
+func MapVolumes(o Options, set *JobSet) error {
+	for _, job := range set.Jobs {
+		updateVolumes(o, job.Base())
+	}
+
+	return nil
+}
+
+// updateVolume updates a volume name and the secret, configmap or persistent volume claim it references.
+func updateVolume(o Options, vol *v1.Volume) {
+	vol.Name = mapVolumeName(o, vol.Name)
+
+	switch {
+	case vol.Secret != nil:
+		vol.Secret.SecretName = mapVolumeName(o, vol.Secret.SecretName)
+	case vol.ConfigMap != nil:
+		vol.ConfigMap.Name = mapVolumeName(o, vol.ConfigMap.Name)
+	case vol.PersistentVolumeClaim != nil:
+		vol.PersistentVolumeClaim.ClaimName = mapVolumeName(o, vol.PersistentVolumeClaim.ClaimName)
+	case vol.Projected != nil:
+		for i := range vol.Projected.Sources {
+			if s := vol.Projected.Sources[i].Secret; s != nil {
+				s.Name = mapVolumeName(o, s.Name)
+			}
+			if c := vol.Projected.Sources[i].ConfigMap; c != nil {
+				c.Name = mapVolumeName(o, c.Name)
+			}
+		}
+	}
+}
+
+// updateContainerVolumes updates a container's volume mount names and env references to secrets and configmaps.
+func updateContainerVolumes(o Options, container *v1.Container) {
+	for i := range container.VolumeMounts {
+		container.VolumeMounts[i].Name = mapVolumeName(o, container.VolumeMounts[i].Name)
+	}
+
+	for _, env := range container.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if s := env.ValueFrom.SecretKeyRef; s != nil {
+			s.Name = mapVolumeName(o, s.Name)
+		}
+		if c := env.ValueFrom.ConfigMapKeyRef; c != nil {
+			c.Name = mapVolumeName(o, c.Name)
+		}
+	}
+
+	for _, envFrom := range container.EnvFrom {
+		if s := envFrom.SecretRef; s != nil {
+			s.Name = mapVolumeName(o, s.Name)
+		}
+		if c := envFrom.ConfigMapRef; c != nil {
+			c.Name = mapVolumeName(o, c.Name)
+		}
+	}
+}
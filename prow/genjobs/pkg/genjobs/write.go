@@ -0,0 +1,425 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genjobs
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/config"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/test-infra/prow/genjobs/pkg/util"
+)
+
+// sortJobs sorts jobs based on a provided sort order.
+func sortJobs(o Options, pre map[string][]config.Presubmit, post map[string][]config.Postsubmit, per []config.Periodic) {
+	if o.Sort == "" {
+		return
+	}
+
+	choices := strings.Join([]string{string(ascending), string(descending)}, "|")
+	matches := regexp.MustCompile(`^(` + choices + `)(?:ending)?$`).FindStringSubmatch(o.Sort)
+	if len(matches) < 2 {
+		return
+	}
+
+	var comparator func(a, b string) bool
+
+	switch sortOrder(matches[1]) {
+	case ascending:
+		comparator = func(a, b string) bool {
+			return a < b
+		}
+	case descending:
+		comparator = func(a, b string) bool {
+			return a > b
+		}
+	}
+
+	for _, c := range pre {
+		sort.Slice(c, func(a, b int) bool {
+			return comparator(c[a].Name, c[b].Name)
+		})
+	}
+
+	for _, c := range post {
+		sort.Slice(c, func(a, b int) bool {
+			return comparator(c[a].Name, c[b].Name)
+		})
+	}
+
+	sort.Slice(per, func(a, b int) bool {
+		return comparator(per[a].Name, per[b].Name)
+	})
+
+}
+
+// getOutPath derives the output path from the specified input directory and current path.
+func getOutPath(o Options, p string, in string) string {
+	segments := strings.FieldsFunc(strings.TrimPrefix(p, in), func(c rune) bool { return c == '/' })
+
+	var (
+		org  string
+		repo string
+		file string
+	)
+
+	switch {
+	case util.HasExtension(o.Output, yamlExt):
+		return o.Output
+	case len(segments) >= 3:
+		org = segments[len(segments)-3]
+		repo = segments[len(segments)-2]
+		file = segments[len(segments)-1]
+		if newOrg, ok := o.OrgMap[org]; ok {
+			filename := util.RenameFile(`^`+util.NormalizeOrg(org, filenameSeparator)+`\b`, file, util.NormalizeOrg(newOrg, filenameSeparator))
+			return filepath.Join(o.Output, util.GetTopLevelOrg(newOrg), repo, filename)
+		}
+	case len(segments) == 2:
+		org = segments[len(segments)-2]
+		file = segments[len(segments)-1]
+		if newOrg, ok := o.OrgMap[org]; ok {
+			filename := util.RenameFile(`^`+util.NormalizeOrg(org, filenameSeparator)+`\b`, file, util.NormalizeOrg(newOrg, filenameSeparator))
+			return filepath.Join(o.Output, util.GetTopLevelOrg(newOrg), filename)
+		}
+	case len(segments) == 1:
+		file = segments[len(segments)-1]
+		if !strings.HasPrefix(file, o.Modifier) {
+			return filepath.Join(o.Output, o.Modifier+filenameSeparator+file)
+		}
+	case len(segments) == 0:
+		file = filepath.Base(in)
+		if !strings.HasPrefix(file, o.Modifier) {
+			return filepath.Join(o.Output, o.Modifier+filenameSeparator+file)
+		}
+	}
+
+	return ""
+}
+
+// getRepoOutPath derives the output path of a job assigned to a repo from the specified input path, as if the input
+// file was located in the directory of the repo.
+func getRepoOutPath(o Options, orgrepo string, p string) string {
+	if util.HasExtension(o.Output, yamlExt) {
+		return o.Output
+	}
+
+	org, repo := util.SplitOrgRepo(orgrepo)
+	newOrg := o.OrgMap[org]
+	file := filepath.Base(p)
+
+	filename := util.RenameFile(`^`+util.NormalizeOrg(org, filenameSeparator)+`\b`, file, util.NormalizeOrg(newOrg, filenameSeparator))
+	if filename == file && !strings.HasPrefix(file, o.Modifier) {
+		filename = o.Modifier + filenameSeparator + file
+	}
+
+	return filepath.Join(o.Output, util.GetTopLevelOrg(newOrg), repo, filename)
+}
+
+// getSource derives the source key of an input path, which is the path relative to the input directory.
+func getSource(o Options, p string) string {
+	if util.IsFile(o.Input) {
+		return filepath.Base(p)
+	}
+
+	rel, err := filepath.Rel(o.Input, p)
+	if err != nil {
+		return filepath.Base(p)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// registered is the input and original name a job was generated from.
+type registered struct {
+	input    string
+	original string
+}
+
+// registry records the input and original name of every job generated during a run in order to detect name
+// collisions, across all job sets writing to the same output path.
+type registry struct {
+	jobs map[string]registered
+}
+
+// newRegistry creates an empty registry.
+func newRegistry() *registry {
+	return &registry{jobs: map[string]registered{}}
+}
+
+// register records the input and original name of a generated job and errors if a different input, or a job with a
+// different original name, already generated a job with the same name for the same output path. Periodics are not
+// keyed by org/repo, as they share a single list in an output file.
+func (r *registry) register(outPath string, job *Job, input string) error {
+	jType, name, orgrepo := job.Type(), job.Base().Name, job.OrgRepo
+	if job.Periodic != nil {
+		orgrepo = ""
+	}
+
+	original := job.Original
+	if original == "" {
+		original = name
+	}
+
+	key := strings.Join([]string{outPath, jType, orgrepo, name}, "|")
+
+	if existing, ok := r.jobs[key]; ok {
+		if existing.input != input {
+			return fmt.Errorf("%s %q for %v is generated from both %v and %v", jType, name, outPath, existing.input, input)
+		}
+		if existing.original != original {
+			return fmt.Errorf("%s %q for %v is generated from both %q and %q of %v", jType, name, outPath, existing.original, original, input)
+		}
+	}
+	r.jobs[key] = registered{input: input, original: original}
+
+	return nil
+}
+
+// registerJobs records the input and original name of all generated jobs.
+func (r *registry) registerJobs(outPath string, input string, jobs []*Job) error {
+	for _, job := range jobs {
+		if err := r.register(outPath, job, input); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cleanOutFile deletes a path and any children, once per run.
+func cleanOutFile(out *outputs, p string) {
+	if err := out.clean(p); err != nil {
+		util.PrintErr(fmt.Sprintf("unable to clean file %v: %v.", p, err))
+	}
+}
+
+// isReplaced checks if an existing job is replaced by a generated job of the same name, or is a stale job from the
+// same source which is pruned. An existing job generated from a different source is a collision. The source of a job
+// is only recorded with --prune or --provenance, so a job of another run without them is replaced.
+func isReplaced(o Options, source string, job config.JobBase, names sets.String) (bool, error) {
+	existingSource, generated := job.Annotations[sourceAnnotation]
+
+	if names.Has(job.Name) {
+		if generated && existingSource != source {
+			return false, fmt.Errorf("job %q generated from %v collides with existing job generated from %v", job.Name, source, existingSource)
+		}
+		return true, nil
+	}
+
+	return o.Prune && generated && existingSource == source, nil
+}
+
+// writeOutFile writes presubmit and postsubmit jobs definitions to the designated output path.
+// Existing jobs are merged by name: jobs with the same name are replaced and unrelated jobs are kept.
+func writeOutFile(o Options, out *outputs, p string, source string, pre map[string][]config.Presubmit, post map[string][]config.Postsubmit, per []config.Periodic) error {
+	if len(pre) == 0 && len(post) == 0 && len(per) == 0 && (!o.Prune || !out.exists(p)) {
+		return nil
+	}
+
+	combinedPre := map[string][]config.Presubmit{}
+	combinedPost := map[string][]config.Postsubmit{}
+	combinedPer := []config.Periodic{}
+
+	var existingJobs config.JobConfig
+	existing, err := out.read(p)
+	if err == nil {
+		err = yaml.Unmarshal(existing, &existingJobs)
+	}
+	if err == nil {
+		if existingJobs.PresubmitsStatic != nil {
+			combinedPre = existingJobs.PresubmitsStatic
+		}
+		if existingJobs.PostsubmitsStatic != nil {
+			combinedPost = existingJobs.PostsubmitsStatic
+		}
+		if existingJobs.Periodics != nil {
+			combinedPer = existingJobs.Periodics
+		}
+	}
+
+	// Combine presubmits
+	for orgrepo, oldPre := range combinedPre {
+		names := sets.NewString()
+		for _, job := range pre[orgrepo] {
+			names.Insert(job.Name)
+		}
+
+		var keptPre []config.Presubmit
+		for _, job := range oldPre {
+			replaced, err := isReplaced(o, source, job.JobBase, names)
+			if err != nil {
+				return err
+			}
+			if !replaced {
+				keptPre = append(keptPre, job)
+			}
+		}
+
+		if len(keptPre) == 0 {
+			delete(combinedPre, orgrepo)
+		} else {
+			combinedPre[orgrepo] = keptPre
+		}
+	}
+	for orgrepo, newPre := range pre {
+		combinedPre[orgrepo] = append(combinedPre[orgrepo], newPre...)
+	}
+
+	// Combine postsubmits
+	for orgrepo, oldPost := range combinedPost {
+		names := sets.NewString()
+		for _, job := range post[orgrepo] {
+			names.Insert(job.Name)
+		}
+
+		var keptPost []config.Postsubmit
+		for _, job := range oldPost {
+			replaced, err := isReplaced(o, source, job.JobBase, names)
+			if err != nil {
+				return err
+			}
+			if !replaced {
+				keptPost = append(keptPost, job)
+			}
+		}
+
+		if len(keptPost) == 0 {
+			delete(combinedPost, orgrepo)
+		} else {
+			combinedPost[orgrepo] = keptPost
+		}
+	}
+	for orgrepo, newPost := range post {
+		combinedPost[orgrepo] = append(combinedPost[orgrepo], newPost...)
+	}
+
+	// Combine periodics
+	names := sets.NewString()
+	for _, job := range per {
+		names.Insert(job.Name)
+	}
+
+	keptPer := []config.Periodic{}
+	for _, job := range combinedPer {
+		replaced, err := isReplaced(o, source, job.JobBase, names)
+		if err != nil {
+			return err
+		}
+		if !replaced {
+			keptPer = append(keptPer, job)
+		}
+	}
+	combinedPer = append(keptPer, per...)
+
+	// Sort presubmits, postsubmits, and periodics
+	sortJobs(o, combinedPre, combinedPost, combinedPer)
+
+	jobConfig := config.JobConfig{}
+
+	if err := jobConfig.SetPresubmits(combinedPre); err != nil {
+		return fmt.Errorf("unable to set presubmits for path %v: %v", p, err)
+	}
+
+	if err := jobConfig.SetPostsubmits(combinedPost); err != nil {
+		return fmt.Errorf("unable to set postsubmits for path %v: %v", p, err)
+	}
+
+	jobConfig.Periodics = combinedPer
+
+	jobConfigYaml, err := yaml.Marshal(jobConfig)
+	if err != nil {
+		return fmt.Errorf("unable to marshal jobs for path %v: %v", p, err)
+	}
+
+	outBytes := []byte(autogenHeader)
+	outBytes = append(outBytes, jobConfigYaml...)
+
+	if err := out.write(p, outBytes); err != nil {
+		return fmt.Errorf("unable to write jobs to path %v: %v", p, err)
+	}
+
+	return nil
+}
+
+// WriteJobs merges the jobs into their output files, unless in dry run mode.
+func (g *Generator) WriteJobs(o Options, set *JobSet) error {
+	outPaths := []string{set.Output}
+	outJobs := map[string][]*Job{}
+
+	for _, job := range set.Jobs {
+		outPath := set.Output
+		if job.Output != "" {
+			outPath = job.Output
+		}
+
+		if _, ok := outJobs[outPath]; !ok && outPath != set.Output {
+			outPaths = append(outPaths, outPath)
+		}
+		outJobs[outPath] = append(outJobs[outPath], job)
+	}
+
+	for _, outPath := range outPaths {
+		if err := g.writeJobs(o, set, outPath, outJobs[outPath]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeJobs merges jobs of a job set into an output file. Every output file a job set writes to is cleaned, including
+// the output file of the repo periodics are assigned to, but only the first time it is written to during the run.
+func (g *Generator) writeJobs(o Options, set *JobSet, outPath string, jobs []*Job) error {
+	presubmit := map[string][]config.Presubmit{}
+	postsubmit := map[string][]config.Postsubmit{}
+	periodic := []config.Periodic{}
+
+	for _, job := range jobs {
+		switch {
+		case job.Presubmit != nil:
+			presubmit[job.OrgRepo] = append(presubmit[job.OrgRepo], *job.Presubmit)
+		case job.Postsubmit != nil:
+			postsubmit[job.OrgRepo] = append(postsubmit[job.OrgRepo], *job.Postsubmit)
+		default:
+			periodic = append(periodic, *job.Periodic)
+		}
+	}
+
+	if err := g.reg.registerJobs(outPath, set.Input, jobs); err != nil {
+		return err
+	}
+
+	if o.Verbose {
+		fmt.Printf("write %d presubmits, %d postsubmits, and %d periodics to path %v\n", len(presubmit), len(postsubmit), len(periodic), outPath)
+	}
+
+	if o.Clean {
+		cleanOutFile(g.out, outPath)
+	}
+
+	if o.DryRun {
+		return nil
+	}
+
+	return writeOutFile(o, g.out, outPath, set.Source, presubmit, postsubmit, periodic)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	globChars = "*?["
+)
+
+// Pattern is a compiled exact, glob, or regular expression string matcher.
+type Pattern struct {
+	Rule string
+	re   *regexp.Regexp
+}
+
+// PatternSet is an ordered list of patterns.
+type PatternSet []Pattern
+
+// NewPattern compiles a rule into a pattern.
+// Rules anchored with `^` or `$` are regular expressions, rules containing any of `*?[` are globs, and all other rules
+// are exact matches.
+func NewPattern(rule string) (Pattern, error) {
+	var expr string
+
+	switch {
+	case strings.HasPrefix(rule, "^") || strings.HasSuffix(rule, "$"):
+		expr = rule
+	case strings.ContainsAny(rule, globChars):
+		expr = `^` + GlobToRegexp(rule) + `$`
+	default:
+		expr = `^` + regexp.QuoteMeta(rule) + `$`
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Pattern{}, fmt.Errorf("invalid pattern %q: %v", rule, err)
+	}
+
+	return Pattern{Rule: rule, re: re}, nil
+}
+
+// NewPatternSet compiles a list of rules into a pattern set.
+func NewPatternSet(rules ...string) (PatternSet, error) {
+	set := make(PatternSet, 0, len(rules))
+
+	for _, rule := range rules {
+		p, err := NewPattern(rule)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, p)
+	}
+
+	return set, nil
+}
+
+// MatchString checks if a string matches the pattern.
+func (p Pattern) MatchString(s string) bool {
+	return p.re.MatchString(s)
+}
+
+// Match returns the rule of the first pattern that matches a string.
+func (s PatternSet) Match(v string) (string, bool) {
+	for _, p := range s {
+		if p.MatchString(v) {
+			return p.Rule, true
+		}
+	}
+
+	return "", false
+}
+
+// Has checks if any pattern matches a string.
+func (s PatternSet) Has(v string) bool {
+	_, ok := s.Match(v)
+	return ok
+}
+
+// GlobToRegexp converts a glob expression into an unanchored regular expression.
+func GlobToRegexp(glob string) string {
+	var b strings.Builder
+
+	inClass := false
+
+	for _, c := range glob {
+		switch {
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+			b.WriteRune(c)
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteString(".")
+		case c == '[':
+			inClass = true
+			b.WriteRune(c)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2019 Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+)
+
+func TestPatternSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []string
+		input    string
+		expected string
+		match    bool
+	}{
+		{
+			name:     "exact match",
+			rules:    []string{"integ-k8s"},
+			input:    "integ-k8s",
+			expected: "integ-k8s",
+			match:    true,
+		},
+		{
+			name:  "exact no partial match",
+			rules: []string{"integ"},
+			input: "integ-k8s",
+			match: false,
+		},
+		{
+			name:  "exact metacharacters are literal",
+			rules: []string{"release-1.5"},
+			input: "release-105",
+			match: false,
+		},
+		{
+			name:     "glob star",
+			rules:    []string{"integ-*"},
+			input:    "integ-pilot-k8s-tests",
+			expected: "integ-*",
+			match:    true,
+		},
+		{
+			name:     "glob question mark",
+			rules:    []string{"release-1.?"},
+			input:    "release-1.5",
+			expected: "release-1.?",
+			match:    true,
+		},
+		{
+			name:     "glob character class",
+			rules:    []string{"release-1.[45]"},
+			input:    "release-1.4",
+			expected: "release-1.[45]",
+			match:    true,
+		},
+		{
+			name:  "glob is anchored",
+			rules: []string{"integ-*"},
+			input: "unit-integ-k8s",
+			match: false,
+		},
+		{
+			name:     "regex",
+			rules:    []string{"^release-.*"},
+			input:    "release-1.5",
+			expected: "^release-.*",
+			match:    true,
+		},
+		{
+			name:     "first matching rule",
+			rules:    []string{"lint", "^integ-.*$", "integ-*"},
+			input:    "integ-k8s",
+			expected: "^integ-.*$",
+			match:    true,
+		},
+		{
+			name:  "empty set",
+			input: "integ-k8s",
+			match: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := NewPatternSet(test.rules...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			actual, match := set.Match(test.input)
+
+			if match != test.match || actual != test.expected {
+				t.Errorf("Actual: %v, %v ; Expected: %v, %v", actual, match, test.expected, test.match)
+			}
+		})
+	}
+}
+
+func TestNewPatternInvalid(t *testing.T) {
+	if _, err := NewPattern("^release-(.*"); err == nil {
+		t.Error("expected error for invalid regular expression")
+	}
+}
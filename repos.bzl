@@ -539,6 +539,15 @@ def go_repositories():
         version = "v2.2.4",
     )
 
+    go_repository(
+        name = "in_gopkg_yaml_v3",
+        build_file_generation = "on",
+        build_file_proto_mode = "disable",
+        importpath = "gopkg.in/yaml.v3",
+        sum = "h1:0efs3hwEZhFKsCoP8l6dDB1AZWMgnEl3yWXWRZTOaEA=",
+        version = "v3.0.0-20190709130402-674ba3eaed22",
+    )
+
     go_repository(
         name = "io_k8s_api",
         build_file_generation = "on",